@@ -4,27 +4,37 @@ import (
 	"context"
 	"errors"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	firebase "firebase.google.com/go/v4"
+	"github.com/gin-gonic/gin"
 	"github.com/mdobak/go-xerrors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.opentelemetry.io/contrib/exporters/autoexport"
 	"go.opentelemetry.io/contrib/propagators/autoprop"
 	"go.opentelemetry.io/otel"
+	otelprometheus "go.opentelemetry.io/otel/exporters/prometheus"
 	noopmeter "go.opentelemetry.io/otel/metric/noop"
 	"go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/trace"
 	nooptrace "go.opentelemetry.io/otel/trace/noop"
 
 	"github.com/khaledhikmat/family-meeting/server"
+	"github.com/khaledhikmat/family-meeting/service/dispatch"
 	"github.com/khaledhikmat/family-meeting/service/lgr"
+	"github.com/khaledhikmat/family-meeting/service/signaling"
+	"github.com/khaledhikmat/family-meeting/service/storage"
 
 	"github.com/khaledhikmat/family-meeting/mode"
+	"github.com/khaledhikmat/family-meeting/mode/all"
 	"github.com/khaledhikmat/family-meeting/mode/broadcast"
+	"github.com/khaledhikmat/family-meeting/mode/loadtest"
 	"github.com/khaledhikmat/family-meeting/mode/monitor"
+	"github.com/khaledhikmat/family-meeting/mode/play"
 )
 
 const (
@@ -34,6 +44,30 @@ const (
 var modeProcs = map[string]mode.Processor{
 	"monitor":   monitor.Processor,
 	"broadcast": broadcast.Processor,
+	"all":       all.Processor,
+	"play":      play.Processor,
+	"loadtest":  loadtest.Processor,
+}
+
+var modeRouters = map[string]mode.Router{
+	"broadcast": broadcast.Router,
+	"all":       broadcast.Router,
+	"play":      broadcast.Router,
+}
+
+var signalingStores = map[string]func(ctx context.Context) (signaling.SignalingStore, error){
+	"firestore": signaling.NewFirestore,
+	"memory":    signaling.NewMemory,
+}
+
+var dispatchers = map[string]func(ctx context.Context) (dispatch.Dispatcher, error){
+	"pubsub": dispatch.NewPubSub,
+	"memory": dispatch.NewMemory,
+}
+
+var recordingSinks = map[string]func(ctx context.Context) (storage.RecordingSink, error){
+	"local": storage.NewLocal,
+	"s3":    storage.NewS3,
 }
 
 func main() {
@@ -54,7 +88,7 @@ func main() {
 	}()
 
 	// Setup OpenTelemetry
-	shutdown, err := setupOpenTelemetry(rootCtx)
+	shutdown, metricsHandler, err := setupOpenTelemetry(rootCtx)
 	if err != nil {
 		lgr.Logger.Error(
 			"setting up OpenTelemetry",
@@ -72,60 +106,137 @@ func main() {
 		}
 	}()
 
-	app, err := firebase.NewApp(context.Background(), nil)
-	if err != nil {
+	mode := "monitor"
+	args := os.Args[1:]
+	if len(args) > 0 {
+		mode = args[0]
+	}
+
+	// Determine the mode processor
+	proc, ok := modeProcs[mode]
+	if !ok {
 		lgr.Logger.Error(
-			"initializing firebase app",
-			slog.Any("error", xerrors.New(err.Error())),
+			"setting up mode processor",
+			slog.Any("error", xerrors.New("unknown mode: "+mode)),
+		)
+		return
+	}
+
+	// Determine the signaling store
+	storeName := "firestore"
+	if os.Getenv("SIGNALING_STORE") != "" {
+		storeName = os.Getenv("SIGNALING_STORE")
+	}
+
+	newStore, ok := signalingStores[storeName]
+	if !ok {
+		lgr.Logger.Error(
+			"setting up signaling store",
+			slog.Any("error", xerrors.New("unknown signaling store: "+storeName)),
 		)
 		return
 	}
 
-	db, err := app.Firestore(rootCtx)
+	store, err := newStore(rootCtx)
 	if err != nil {
 		lgr.Logger.Error(
-			"acquiring firestore client",
+			"creating signaling store",
 			slog.Any("error", xerrors.New(err.Error())),
 		)
 		return
 	}
+	defer store.Close()
+
+	// Determine the dispatcher
+	// The all and play modes run monitor and broadcast in one process so they dispatch in-memory by default.
+	// The loadtest mode does not dispatch at all.
+	dispatcherName := "pubsub"
+	if mode == "all" || mode == "play" || mode == "loadtest" {
+		dispatcherName = "memory"
+	}
+	if os.Getenv("DISPATCHER") != "" {
+		dispatcherName = os.Getenv("DISPATCHER")
+	}
 
-	// Create an error stream
-	errorStream := make(chan error)
-	defer close(errorStream)
+	newDispatcher, ok := dispatchers[dispatcherName]
+	if !ok {
+		lgr.Logger.Error(
+			"setting up dispatcher",
+			slog.Any("error", xerrors.New("unknown dispatcher: "+dispatcherName)),
+		)
+		return
+	}
 
-	// Create a completion stream
-	completionStream := make(chan error)
-	defer close(completionStream)
+	dispatcher, err := newDispatcher(rootCtx)
+	if err != nil {
+		lgr.Logger.Error(
+			"creating dispatcher",
+			slog.Any("error", xerrors.New(err.Error())),
+		)
+		return
+	}
+	defer dispatcher.Close()
 
-	mode := "monitor"
-	args := os.Args[1:]
-	if len(args) > 0 {
-		mode = args[0]
+	// Determine the recording sink
+	sinkName := "local"
+	if os.Getenv("RECORDING_SINK") != "" {
+		sinkName = os.Getenv("RECORDING_SINK")
 	}
 
-	// Determine the mode processor
-	proc, ok := modeProcs[mode]
+	newSink, ok := recordingSinks[sinkName]
 	if !ok {
 		lgr.Logger.Error(
-			"setting up mode processor",
+			"setting up recording sink",
+			slog.Any("error", xerrors.New("unknown recording sink: "+sinkName)),
+		)
+		return
+	}
+
+	sink, err := newSink(rootCtx)
+	if err != nil {
+		lgr.Logger.Error(
+			"creating recording sink",
 			slog.Any("error", xerrors.New(err.Error())),
 		)
 		return
 	}
+	defer sink.Close()
+
+	// Create an error stream
+	errorStream := make(chan error)
+	defer close(errorStream)
+
+	// Create a completion stream
+	completionStream := make(chan error)
+	defer close(completionStream)
 
 	// Run the mode processor
 	go func() {
-		err := proc(canxCtx, app, db, errorStream)
+		err := proc(canxCtx, store, dispatcher, sink, errorStream)
 		if err != nil {
 			errorStream <- err
 		}
 		completionStream <- err
 	}()
 
+	// Collect the mode http endpoints
+	routes := []server.Route{}
+	if router, ok := modeRouters[mode]; ok {
+		routes = router(canxCtx, store, sink, errorStream)
+	}
+
+	// Expose the metrics to be scraped when the Prometheus exporter is selected
+	if metricsHandler != nil {
+		routes = append(routes, server.Route{
+			Method:  http.MethodGet,
+			Path:    "/metrics",
+			Handler: gin.WrapH(metricsHandler),
+		})
+	}
+
 	// Run the http server
 	go func() {
-		err = server.Run(canxCtx, errorStream, os.Getenv("APP_PORT"))
+		err = server.Run(canxCtx, errorStream, os.Getenv("APP_PORT"), routes)
 		if err != nil {
 			errorStream <- err
 		}
@@ -213,7 +324,9 @@ resume:
 // https://cloud.google.com/stackdriver/docs/instrumentation/setup/go
 // setupOpenTelemetry sets up the OpenTelemetry SDK and exporters for metrics and
 // traces. If it does not return an error, call shutdown for proper cleanup.
-func setupOpenTelemetry(ctx context.Context) (shutdown func(context.Context) error, err error) {
+// The metrics handler serves the metrics to Prometheus if METRICS_EXPORTER is `prometheus`.
+// Otherwise, it is nil and the metrics are pushed as configured by OTEL_METRICS_EXPORTER.
+func setupOpenTelemetry(ctx context.Context) (shutdown func(context.Context) error, metricsHandler http.Handler, err error) {
 	if os.Getenv("DISABLE_TELEMETRY") == "true" {
 		// Set Noop Tracer Provider
 		otel.SetTracerProvider(nooptrace.NewTracerProvider())
@@ -224,7 +337,7 @@ func setupOpenTelemetry(ctx context.Context) (shutdown func(context.Context) err
 		// Return a no-op shutdown function
 		return func(_ context.Context) error {
 			return nil
-		}, nil
+		}, nil, nil
 	}
 
 	var shutdownFuncs []func(context.Context) error
@@ -253,8 +366,23 @@ func setupOpenTelemetry(ctx context.Context) (shutdown func(context.Context) err
 	shutdownFuncs = append(shutdownFuncs, tp.Shutdown)
 	otel.SetTracerProvider(tp)
 
-	// Configure Metric Export to send metrics as OTLP
-	mreader, err := autoexport.NewMetricReader(ctx)
+	// Configure Metric Export to send metrics as OTLP or to be scraped by Prometheus
+	var mreader metric.Reader
+	if os.Getenv("METRICS_EXPORTER") == "prometheus" {
+		// Use a dedicated registry so only the OTEL metrics are served
+		registry := prometheus.NewRegistry()
+		// The exporter fails the scrape when an up/down counter (a Prometheus gauge) carries
+		// exemplars i.e. when it is updated within a sampled span. Drop the exemplars unless
+		// configured otherwise.
+		if os.Getenv("OTEL_METRICS_EXEMPLAR_FILTER") == "" {
+			os.Setenv("OTEL_METRICS_EXEMPLAR_FILTER", "always_off")
+		}
+
+		mreader, err = otelprometheus.New(otelprometheus.WithRegisterer(registry))
+		metricsHandler = promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	} else {
+		mreader, err = autoexport.NewMetricReader(ctx)
+	}
 	if err != nil {
 		err = errors.Join(err, shutdown(ctx))
 		return
@@ -265,5 +393,5 @@ func setupOpenTelemetry(ctx context.Context) (shutdown func(context.Context) err
 	shutdownFuncs = append(shutdownFuncs, mp.Shutdown)
 	otel.SetMeterProvider(mp)
 
-	return shutdown, nil
+	return shutdown, metricsHandler, nil
 }
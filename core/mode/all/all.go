@@ -0,0 +1,53 @@
+package all
+
+import (
+	"context"
+	"errors"
+
+	"github.com/khaledhikmat/family-meeting/mode"
+	"github.com/khaledhikmat/family-meeting/mode/broadcast"
+	"github.com/khaledhikmat/family-meeting/mode/monitor"
+	"github.com/khaledhikmat/family-meeting/service/dispatch"
+	"github.com/khaledhikmat/family-meeting/service/lgr"
+	"github.com/khaledhikmat/family-meeting/service/signaling"
+	"github.com/khaledhikmat/family-meeting/service/storage"
+)
+
+// Processor runs the monitor and broadcast processors concurrently in one process.
+// They share the same signaling store, dispatcher and recording sink which is in-memory by default
+// so no Pub/Sub topic is required.
+func Processor(canxCtx context.Context,
+	store signaling.SignalingStore,
+	dispatcher dispatch.Dispatcher,
+	sink storage.RecordingSink,
+	errorStream chan error) error {
+
+	lgr.Logger.Info("all proc started")
+
+	// Get a child context so that if one processor exits, the other one is stopped
+	procsCanxCtx, procsCanxFn := context.WithCancel(canxCtx)
+	defer procsCanxFn()
+
+	procs := []mode.Processor{
+		monitor.Processor,
+		broadcast.Processor,
+	}
+
+	completionStream := make(chan error, len(procs))
+	for _, proc := range procs {
+		go func(proc mode.Processor) {
+			completionStream <- proc(procsCanxCtx, store, dispatcher, sink, errorStream)
+		}(proc)
+	}
+
+	// Wait for the first processor to complete and stop the rest
+	err := <-completionStream
+	procsCanxFn()
+
+	for i := 1; i < len(procs); i++ {
+		err = errors.Join(err, <-completionStream)
+	}
+
+	lgr.Logger.Info("all proc completed")
+	return err
+}
@@ -0,0 +1,238 @@
+package broadcast
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/khaledhikmat/family-meeting/service/signaling"
+	"github.com/khaledhikmat/family-meeting/utils"
+)
+
+const (
+	adminTokenKey = "ADMIN_TOKEN"
+
+	// Written to a kicked participant request so the client can tell why it was disconnected
+	rejectKicked = "removed from the meeting"
+)
+
+type adminTrack struct {
+	Kind    string `json:"kind"`
+	Codec   string `json:"codec"`
+	Bitrate int64  `json:"bitrate"`
+	HLS     bool   `json:"hls"`
+}
+
+type adminParticipant struct {
+	ID       string    `json:"id"`
+	Kind     string    `json:"kind"`
+	JoinedAt time.Time `json:"joinedAt"`
+	Uptime   string    `json:"uptime"`
+}
+
+type adminBroadcast struct {
+	ID           string             `json:"id"`
+	StartedAt    time.Time          `json:"startedAt"`
+	Uptime       string             `json:"uptime"`
+	Tracks       []adminTrack       `json:"tracks"`
+	Participants []adminParticipant `json:"participants"`
+	// Taken from the request when the store has it
+	Kind      string `json:"kind,omitempty"`
+	Requestor string `json:"requestor,omitempty"`
+	Status    string `json:"status,omitempty"`
+}
+
+// adminAuth only lets requests bearing the ADMIN_TOKEN through to the handler
+func adminAuth(handler gin.HandlerFunc) gin.HandlerFunc {
+	return tokenAuth(handler, adminTokenKey)
+}
+
+// tokenAuth only lets requests bearing the token of one of the env keys through to the handler.
+// Keys that are not set are ignored so nobody gets through if none is set.
+func tokenAuth(handler gin.HandlerFunc, keys ...string) gin.HandlerFunc {
+	tokens := [][]byte{}
+	for _, key := range keys {
+		if token := os.Getenv(key); token != "" {
+			tokens = append(tokens, []byte(token))
+		}
+	}
+
+	return func(c *gin.Context) {
+		allowCORS(c)
+
+		bearer, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || !matchToken([]byte(bearer), tokens) {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.String(http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		handler(c)
+	}
+}
+
+func matchToken(bearer []byte, tokens [][]byte) bool {
+	matched := false
+	for _, token := range tokens {
+		// Compare with every token so the time does not tell which one matched
+		if subtle.ConstantTimeCompare(bearer, token) == 1 {
+			matched = true
+		}
+	}
+
+	return matched
+}
+
+// adminBroadcastsHandler lists the live broadcasts on this instance
+func adminBroadcastsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		broadcasts := []adminBroadcast{}
+		for _, b := range sessions.listBroadcasts() {
+			broadcasts = append(broadcasts, toAdminBroadcast(b))
+		}
+
+		sort.Slice(broadcasts, func(i, j int) bool {
+			return broadcasts[i].StartedAt.Before(broadcasts[j].StartedAt)
+		})
+
+		c.JSON(http.StatusOK, gin.H{
+			"instance":   signaling.InstanceID(),
+			"broadcasts": broadcasts,
+		})
+	}
+}
+
+// adminBroadcastHandler shows a live broadcast along with its request details
+func adminBroadcastHandler(store signaling.SignalingStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		b, ok := findBroadcast(c.Param("id"))
+		if !ok {
+			c.String(http.StatusNotFound, "broadcast not found")
+			return
+		}
+
+		broadcast := toAdminBroadcast(b)
+		if request, err := store.GetRequest(c, b.ID); err == nil {
+			broadcast.Kind = request.Kind
+			broadcast.Requestor = request.Requestor
+			broadcast.Status = request.Status
+		}
+
+		c.JSON(http.StatusOK, broadcast)
+	}
+}
+
+// adminAbortHandler ends a live broadcast for everybody
+func adminAbortHandler(store signaling.SignalingStore,
+	errorStream chan error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		broadcastID := c.Param("id")
+		broadcast, ok := sessions.getBroadcast(broadcastID)
+		if !ok {
+			c.String(http.StatusNotFound, "broadcast not found")
+			return
+		}
+
+		signaling.SetStatus(c, errorStream, store, broadcastID, utils.StatusEnded, "aborted by an admin")
+
+		// The request is aborted so the broadcaster client knows. The broadcast is cancelled
+		// right away in case the store is not reachable.
+		err := store.UpdateRequest(c, broadcastID, map[string]interface{}{
+			"abort": true,
+		})
+		if err != nil {
+			errorStream <- fmt.Errorf("adminAbortHandler store.UpdateRequest error: %v", err)
+		}
+		broadcast.RequestCanxFn()
+
+		c.Status(http.StatusOK)
+	}
+}
+
+// adminKickHandler disconnects a participant from a live broadcast
+func adminKickHandler(store signaling.SignalingStore,
+	errorStream chan error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		broadcastID := c.Param("id")
+		participantID := c.Param("participant")
+
+		b, ok := findBroadcast(broadcastID)
+		if !ok {
+			c.String(http.StatusNotFound, "broadcast not found")
+			return
+		}
+
+		participant, ok := b.Participants[participantID]
+		if !ok || !sessions.cancelParticipant(broadcastID, participantID) {
+			c.String(http.StatusNotFound, "participant not found")
+			return
+		}
+
+		// WHEP viewers have no request to tell
+		if participant.Kind == signalingParticipant {
+			err := store.UpdateRequest(c, participantID, map[string]interface{}{
+				"rejection": rejectKicked,
+			})
+			if err != nil {
+				errorStream <- fmt.Errorf("adminKickHandler store.UpdateRequest error: %v", err)
+			}
+		}
+
+		c.Status(http.StatusOK)
+	}
+}
+
+func findBroadcast(id string) (broadcastSession, bool) {
+	for _, b := range sessions.listBroadcasts() {
+		if b.ID == id {
+			return b, true
+		}
+	}
+
+	return broadcastSession{}, false
+}
+
+func toAdminBroadcast(b broadcastSession) adminBroadcast {
+	broadcast := adminBroadcast{
+		ID:           b.ID,
+		StartedAt:    b.StartedAt,
+		Uptime:       time.Since(b.StartedAt).Round(time.Second).String(),
+		Tracks:       []adminTrack{},
+		Participants: []adminParticipant{},
+	}
+
+	for _, t := range b.Tracks {
+		var bitrate int64
+		if t.Ingress != nil {
+			bitrate = t.Ingress.Bitrate()
+		}
+
+		broadcast.Tracks = append(broadcast.Tracks, adminTrack{
+			Kind:    t.Kind.String(),
+			Codec:   t.Track.Codec().MimeType,
+			Bitrate: bitrate,
+			HLS:     t.HLS != nil,
+		})
+	}
+
+	for _, p := range b.Participants {
+		broadcast.Participants = append(broadcast.Participants, adminParticipant{
+			ID:       p.ID,
+			Kind:     p.Kind,
+			JoinedAt: p.JoinedAt,
+			Uptime:   time.Since(p.JoinedAt).Round(time.Second).String(),
+		})
+	}
+
+	sort.Slice(broadcast.Participants, func(i, j int) bool {
+		return broadcast.Participants[i].JoinedAt.Before(broadcast.Participants[j].JoinedAt)
+	})
+
+	return broadcast
+}
@@ -7,32 +7,36 @@ import (
 	"io"
 	"log/slog"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
-	"cloud.google.com/go/firestore"
-	"cloud.google.com/go/pubsub"
-	firebase "firebase.google.com/go/v4"
 	"github.com/mdobak/go-xerrors"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/khaledhikmat/family-meeting/service/dispatch"
+	"github.com/khaledhikmat/family-meeting/service/ice"
 	"github.com/khaledhikmat/family-meeting/service/lgr"
+	"github.com/khaledhikmat/family-meeting/service/signaling"
+	"github.com/khaledhikmat/family-meeting/service/storage"
 	"github.com/khaledhikmat/family-meeting/utils"
 	"github.com/pion/interceptor"
-	"github.com/pion/interceptor/pkg/intervalpli"
 	"github.com/pion/webrtc/v4"
 )
 
 const (
-	projectID            = "family-meeting-aa853"
-	abortWatcherInterval = 5 * time.Second
-	waitOnTrackTimeout   = 30 * time.Second
-	broadcastsTopic      = "broadcasts"
-	broadcastsSub        = "broadcasts-sub"
+	waitOnTrackTimeout = 30 * time.Second
+	// All local tracks share the same stream ID so browsers lip-sync audio and video
+	localStreamID = "family-meeting"
 )
 
 var (
-	meter = otel.Meter("family.meeting.broadcast")
+	meter  = otel.Meter("family.meeting.broadcast")
+	tracer = otel.Tracer("family.meeting.broadcast")
 
 	receiveDuration metric.Int64Histogram
 )
@@ -52,108 +56,101 @@ func init() {
 	}
 }
 
-// Must match the one configured in client JavaScript
-var peerConnectionConfig = webrtc.Configuration{
-	ICEServers: []webrtc.ICEServer{
-		{
-			URLs: []string{"stun:stun.l.google.com:19302"},
-		},
-	},
+// The ICE servers are shared with the web clients through the `/ice-servers` endpoint
+func peerConnectionConfig() webrtc.Configuration {
+	return webrtc.Configuration{
+		ICEServers: ice.Servers(""),
+	}
 }
 
 type track struct {
 	Ctx   context.Context
 	CtxFn context.CancelFunc
+	Kind  webrtc.RTPCodecType
 	Track *webrtc.TrackLocalStaticRTP
+	// Forwards participant keyframe requests to the broadcaster. Nil for audio tracks.
+	Keyframe *keyframeForwarder
+	// Records the broadcaster packets while recording is on
+	Recorder *trackRecorder
+	// Packages the broadcaster video to HLS. Nil unless the track is H264 video.
+	HLS *hlsPackager
+	// Measures the broadcaster bitrate to estimate the egress to participants
+	Ingress *bitrateMeter
+	// Shared by all the tracks of the broadcast
+	Metrics *mediaMetrics
 }
 
 func Processor(canxCtx context.Context,
-	_ *firebase.App,
-	db *firestore.Client,
+	store signaling.SignalingStore,
+	dispatcher dispatch.Dispatcher,
+	sink storage.RecordingSink,
 	errorStream chan error) error {
 
 	lgr.Logger.Info("broadcast proc started")
 
-	client, err := pubsub.NewClient(canxCtx, projectID)
-	if err != nil {
-		lgr.Logger.Error(
-			"creating pubsub client",
-			slog.Any("error", xerrors.New(err.Error())),
-		)
-		return err
-	}
-	defer client.Close()
-
-	// Make sure topic exists
-	t := client.Topic(broadcastsTopic)
-	defer t.Stop()
-
-	ok, err := t.Exists(canxCtx)
-	if err != nil {
-		lgr.Logger.Error(
-			"checking topic",
-			slog.String("topic", broadcastsTopic),
-			slog.Any("error", xerrors.New(err.Error())),
-		)
-		return err
-	}
-
-	if !ok {
-		lgr.Logger.Error(
-			"checking topic",
-			slog.String("topic", broadcastsTopic),
-			slog.Any("error", xerrors.New("topic does not exist")),
-		)
-		return err
-	}
-
-	// Make sure topic subscription exists
-	sub := client.Subscription(broadcastsSub)
+	// Accept RTMP publishers on this instance
+	go serveRTMP(canxCtx, errorStream, store, sink)
 
-	ok, err = sub.Exists(canxCtx)
-	if err != nil {
-		lgr.Logger.Error(
-			"checking topic sub",
-			slog.String("topic", broadcastsTopic),
-			slog.String("sub", broadcastsSub),
-			slog.Any("error", xerrors.New("topic subscription does not exist")),
+	// Consume events from the dispatcher
+	// Receive blocks until the context is cancelled
+	err := dispatcher.Receive(canxCtx, func(_ context.Context, msg *dispatch.Message) {
+		now := time.Now()
+		// Continue the trace started by the monitor
+		msgCtx := msg.Context(canxCtx)
+		lgr.Logger.InfoContext(msgCtx, "broadcast proc received message",
+			slog.String("msg", string(msg.Data)),
 		)
-		return err
-	}
 
-	if !ok {
-		lgr.Logger.Error(
-			"checking topic sub",
-			slog.String("topic", broadcastsTopic),
-			slog.String("sub", broadcastsSub),
-			slog.Any("error", xerrors.New("topic sub does not exist")),
-		)
-		return err
-	}
+		// Leave the broadcast to another instance when this one is full
+		if !capacity.admitBroadcast() {
+			if capacityExhausted(msgCtx, store, msg) {
+				lgr.Logger.InfoContext(msgCtx, "broadcast proc is at capacity. Failing the broadcast",
+					slog.String("msg", string(msg.Data)),
+					slog.Int("attempts", msg.DeliveryAttempt),
+				)
+				signaling.SetStatus(msgCtx, errorStream, store, string(msg.Data), utils.StatusFailed, failNoCapacity)
+				msg.Ack()
+				return
+			}
 
-	// Consume events from the topic
-	// Receive blocks until a message is received or the context is cancelled
-	// There is more control: https://cloud.google.com/pubsub/docs/samples/pubsub-subscriber-concurrency-control?hl=en
-	err = sub.Receive(canxCtx, func(_ context.Context, msg *pubsub.Message) {
-		now := time.Now()
+			lgr.Logger.InfoContext(msgCtx, "broadcast proc is at capacity. Releasing the message",
+				slog.String("msg", string(msg.Data)),
+			)
+			msg.Nack()
+			return
+		}
 		defer msg.Ack()
-		lgr.Logger.Info("broadcast proc received message",
-			slog.String("msg", string(msg.Data)),
-		)
+
+		// RTSP broadcasts pull from a camera instead of answering an offer
+		if msg.Attributes["kind"] == rtspKind {
+			go func() {
+				defer capacity.releaseBroadcast()
+				startRTSPBroadcaster(msgCtx,
+					errorStream,
+					store,
+					sink,
+					string(msg.Data))
+			}()
+
+			receiveDuration.Record(canxCtx, time.Since(now).Milliseconds())
+			return
+		}
 
 		// Consume from a queue to start broadcasters
-		go startBroadcaster(canxCtx,
-			errorStream,
-			db,
-			string(msg.Data))
+		go func() {
+			defer capacity.releaseBroadcast()
+			startBroadcaster(msgCtx,
+				errorStream,
+				store,
+				sink,
+				string(msg.Data))
+		}()
 
 		receiveDuration.Record(canxCtx, time.Since(now).Milliseconds())
 	})
 	if err != nil {
 		lgr.Logger.Error(
 			"broadcast proc receiving message",
-			slog.String("topic", broadcastsTopic),
-			slog.String("sub", broadcastsSub),
 			slog.Any("error", xerrors.New(err.Error())),
 		)
 
@@ -170,54 +167,43 @@ func Processor(canxCtx context.Context,
 
 func startBroadcaster(canxCtx context.Context,
 	errorStream chan error,
-	db *firestore.Client,
+	store signaling.SignalingStore,
+	sink storage.RecordingSink,
 	broadcastID string) {
-	reqRef := db.Collection("broadcast_requests")
-	broadcastReq := reqRef.Doc(broadcastID)
-	if broadcastReq == nil {
-		errorStream <- fmt.Errorf("startBroadcaster broadcastDoc is nil")
+	if broadcastID == "" {
+		errorStream <- fmt.Errorf("startBroadcaster broadcastID is empty")
 		return
 	}
 
+	// The broadcast is traced until it ends. Its logs carry the trace.
+	canxCtx, span := tracer.Start(canxCtx, "startBroadcaster",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attribute.String("broadcast", broadcastID)),
+	)
+	defer span.End()
+
 	requestCanxCtx, requestCanxFn := context.WithCancel(canxCtx)
 	defer requestCanxFn()
 
 	// Wait until an offer is created by the broadcaster
-	offer := utils.WaitForOffer(canxCtx, requestCanxCtx, errorStream, db, broadcastReq)
-
-	m := &webrtc.MediaEngine{}
-	if err := m.RegisterDefaultCodecs(); err != nil {
-		errorStream <- fmt.Errorf("startBroadcaster RegisterDefaultCodecs error: %v", err)
+	offer := signaling.WaitForOffer(canxCtx, requestCanxCtx, errorStream, store, broadcastID)
+	if offer.SDP == "" {
+		span.SetStatus(codes.Error, "no offer")
 		return
 	}
 
-	// Create a InterceptorRegistry. This is the user configurable RTP/RTCP Pipeline.
-	// This provides NACKs, RTCP Reports and other features. If you use `webrtc.NewPeerConnection`
-	// this is enabled by default. If you are manually managing You MUST create a InterceptorRegistry
-	// for each PeerConnection.
-	i := &interceptor.Registry{}
-
-	// Use the default set of Interceptors
-	if err := webrtc.RegisterDefaultInterceptors(m, i); err != nil {
-		errorStream <- fmt.Errorf("startBroadcaster RegisterDefaultInterceptors error: %v", err)
-		return
-	}
+	signaling.SetStatus(canxCtx, errorStream, store, broadcastID, utils.StatusNegotiating, "")
+	metrics := newMediaMetrics(broadcastID)
 
-	// Register a intervalpli factory
-	// This interceptor sends a PLI every 3 seconds. A PLI causes a video keyframe to be generated by the sender.
-	// This makes our video seekable and more error resilent, but at a cost of lower picture quality and higher bitrates
-	// A real world application should process incoming RTCP packets from viewers and forward them to senders
-	intervalPliFactory, err := intervalpli.NewReceiverInterceptor()
-	if err != nil {
-		errorStream <- fmt.Errorf("startBroadcaster NewReceiverInterceptor error: %v", err)
-		return
-	}
-	i.Add(intervalPliFactory)
+	localTrackStream := make(chan track)
+	defer close(localTrackStream)
 
-	// Create a new RTCPeerConnection
-	peerConnection, err := webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i)).NewPeerConnection(peerConnectionConfig)
+	peerConnection, stats, err := newBroadcasterConnection(canxCtx, requestCanxCtx, errorStream, offer, localTrackStream, metrics,
+		trickleCandidate(canxCtx, errorStream, store, broadcastID))
 	if err != nil {
-		errorStream <- fmt.Errorf("startBroadcaster NewAPI error: %v", err)
+		failSpan(span, err)
+		errorStream <- fmt.Errorf("startBroadcaster %v", err)
+		signaling.SetStatus(canxCtx, errorStream, store, broadcastID, utils.StatusFailed, err.Error())
 		return
 	}
 	defer func() {
@@ -226,162 +212,306 @@ func startBroadcaster(canxCtx context.Context,
 		}
 	}()
 
-	// Allow us to receive 1 video track
-	if _, err = peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo); err != nil {
-		errorStream <- fmt.Errorf("startBroadcaster AddTransceiverFromKind error: %v", err)
-		return
-	}
-
-	localTrackStream := make(chan track)
-	defer close(localTrackStream)
-
-	// Set a handler for when a new remote track starts, this just distributes all our packets
-	// to connected peers
-	peerConnection.OnTrack(func(remoteTrack *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
-		// WARNING: This should happen only once and arrives on its own goroutine
-		lgr.Logger.Info("startBroadcaster onTrack should happen only once")
-		// TODO: For some reason, this happens more than once, we need to investigate why
-		// I am allowing this to happen
-		lgr.Logger.Info("startBroadcaster peerConnection.OnTrack from the remote broadcaster")
-		go onRemoteTrack(canxCtx, requestCanxCtx, errorStream, localTrackStream, remoteTrack, receiver)
-	})
-
-	// Set the remote SessionDescription
-	err = peerConnection.SetRemoteDescription(offer)
+	// Update the answer in the broacast request
+	answerCtx, answerSpan := tracer.Start(canxCtx, "store.SetAnswer")
+	err = store.SetAnswer(answerCtx, broadcastID, utils.Encode(peerConnection.LocalDescription()))
 	if err != nil {
-		errorStream <- fmt.Errorf("startBroadcaster SetRemoteDescription error: %v", err)
-		return
+		failSpan(answerSpan, err)
 	}
-
-	// Create answer
-	answer, err := peerConnection.CreateAnswer(nil)
+	answerSpan.End()
 	if err != nil {
-		errorStream <- fmt.Errorf("startBroadcaster CreateAnswer error: %v", err)
+		failSpan(span, err)
+		errorStream <- fmt.Errorf("startBroadcaster store.SetAnswer error: %v", err)
+		signaling.SetStatus(canxCtx, errorStream, store, broadcastID, utils.StatusFailed, "unable to answer")
 		return
 	}
 
-	// Create channel that is blocked until ICE Gathering is complete
-	gatherComplete := webrtc.GatheringCompletePromise(peerConnection)
-
-	// Sets the LocalDescription, and starts our UDP listeners
-	err = peerConnection.SetLocalDescription(answer)
-	if err != nil {
-		errorStream <- fmt.Errorf("startBroadcaster SetLocalDescription error: %v", err)
-		return
-	}
+	// Apply the candidates trickled by the broadcaster
+	go addRemoteCandidates(canxCtx, requestCanxCtx, errorStream, store, broadcastID, peerConnection)
 
-	// Block until ICE Gathering is complete, disabling trickle ICE
-	// we do this because we only can exchange one signaling message
-	// in a production application you should exchange ICE Candidates via OnICECandidate
-	<-gatherComplete
+	runBroadcaster(canxCtx, requestCanxCtx, requestCanxFn, errorStream, store, sink, broadcastID, localTrackStream, expectedTracks(offer), stats)
+}
 
-	// Update the answer in the broacast request
-	_, err = broadcastReq.Update(canxCtx, []firestore.Update{{
-		Path: "answer", Value: utils.Encode(peerConnection.LocalDescription()),
-	}})
-	if err != nil {
-		errorStream <- fmt.Errorf("startBroadcaster broadcastReq.Update error: %v", err)
-		return
-	}
+// runBroadcaster waits for the broadcaster track(s) and then serves participant requests
+// until the broadcast is cancelled or aborted. Stats are nil if the broadcaster has no peer connection.
+func runBroadcaster(canxCtx context.Context,
+	requestCanxCtx context.Context,
+	requestCanxFn context.CancelFunc,
+	errorStream chan error,
+	store signaling.SignalingStore,
+	sink storage.RecordingSink,
+	broadcastID string,
+	localTrackStream chan track,
+	expectedTracks int,
+	stats *peerStats) {
+	// The broadcast ends when its request is cancelled i.e. aborted.
+	// It fails if no track arrives or this instance shuts down.
+	status, reason := utils.StatusEnded, ""
+	defer func() {
+		if canxCtx.Err() != nil {
+			status, reason = utils.StatusFailed, "instance shut down"
+		}
+		signaling.SetStatus(canxCtx, errorStream, store, broadcastID, status, reason)
+	}()
 
 	// Wait to receive cancellation or abort
+	abortStream := store.WatchAbort(canxCtx, requestCanxCtx, errorStream, broadcastID)
 	go func() {
-		ticker := time.NewTicker(abortWatcherInterval)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-canxCtx.Done():
-				lgr.Logger.Info("abortWatcher context cancelled")
-				return
-			case <-requestCanxCtx.Done():
-				lgr.Logger.Info("abortWatcher request context cancelled")
-				return
-			case <-ticker.C:
-				reqRef := db.Collection("broadcast_requests")
-				broadcastDoc := reqRef.Doc(broadcastReq.ID)
-				docSnap, err := broadcastDoc.Get(canxCtx)
-				if err != nil {
-					errorStream <- fmt.Errorf("abortWatcherfailed to get document: %v", err)
-					continue
-				}
-				var request utils.Request
-				err = docSnap.DataTo(&request)
-				if err != nil {
-					errorStream <- fmt.Errorf("abortWatcher failed to decode document: %v", err)
-					continue
-				}
-
-				if request.Abort {
-					lgr.Logger.Info("abortWatcher aborting the broadcast",
-						slog.String("broadcast", broadcastReq.ID),
-					)
-					requestCanxFn()
-					return
-				}
-			}
+		if _, ok := <-abortStream; ok {
+			lgr.Logger.InfoContext(canxCtx, "runBroadcaster aborting the broadcast",
+				slog.String("broadcast", broadcastID),
+			)
+			requestCanxFn()
 		}
 	}()
 
-	var localTrack track
-	var canxFn context.CancelFunc
+	localTracks := map[webrtc.RTPCodecType]track{}
 
-	// Timer to wait for a remote track to arrive
-	// Unfortunately, this means that the broadcaster will wait for a track to arrive within this time
-	// This also means that the broadcaster will not be able to process participant requests until this time expires
-	// The reason we don't proceed once a track arrives is because it is possible (based on experimentation)
-	// that the onTrack event is called multiple times
+	// Timer to wait for the remote tracks to arrive
+	// The broadcaster offers up to one audio and one video track. Each one fires its own onTrack event.
+	// We proceed as soon as all offered tracks arrive or when the timer expires with whatever arrived.
 	timer := time.NewTimer(waitOnTrackTimeout)
 	defer timer.Stop()
 
-	// Wait to receive cancellation, local track or timeout
-	for {
+	// Waiting for the tracks is traced as part of the broadcast
+	_, tracksSpan := tracer.Start(canxCtx, "runBroadcaster.waitForTracks",
+		trace.WithAttributes(attribute.Int("expected", expectedTracks)),
+	)
+	defer tracksSpan.End()
+
+	// Wait to receive cancellation, local tracks or timeout
+	for len(localTracks) < expectedTracks {
 		select {
 		case <-canxCtx.Done():
-			lgr.Logger.Info("startBroadcaster context cancelled")
+			lgr.Logger.InfoContext(canxCtx, "runBroadcaster context cancelled")
 			return
 		case <-requestCanxCtx.Done():
-			lgr.Logger.Info("startBroadcaster request context cancelled")
+			lgr.Logger.InfoContext(canxCtx, "runBroadcaster request context cancelled")
 			return
 		case <-timer.C:
 			// Timer expired, resume with waiting on participant requests
-			lgr.Logger.Info(
-				"startBroadcaster timeout to receive a remote track occurred. Resume.",
+			lgr.Logger.InfoContext(canxCtx,
+				"runBroadcaster timeout to receive all remote tracks occurred. Resume.",
+				slog.Int("expected", expectedTracks),
+				slog.Int("received", len(localTracks)),
 			)
+			tracksSpan.AddEvent("timeout")
 			goto resume
-		case localTrack = <-localTrackStream:
-			lgr.Logger.Info("startBroadcaster received a remote track. Now I can accept participants")
-			if canxFn != nil {
-				lgr.Logger.Info("startBroadcaster received a remote track. Cancelling previous track context")
-				canxFn()
+		case localTrack := <-localTrackStream:
+			lgr.Logger.InfoContext(canxCtx, "runBroadcaster received a remote track",
+				slog.String("kind", localTrack.Kind.String()),
+			)
+			tracksSpan.AddEvent("track received", trace.WithAttributes(
+				attribute.String("kind", localTrack.Kind.String()),
+			))
+			if previous, ok := localTracks[localTrack.Kind]; ok {
+				lgr.Logger.InfoContext(canxCtx, "runBroadcaster received a remote track of the same kind. Cancelling previous track context")
+				previous.CtxFn()
 			}
-			canxFn = localTrack.CtxFn
+			localTracks[localTrack.Kind] = localTrack
 		}
 	}
 
 resume:
-	// if the local track is still nil, the broadcaster will exit immediately
-	if localTrack.Track == nil {
-		errorStream <- fmt.Errorf("startBroadcaster did not receive a track in %v. Exiting", waitOnTrackTimeout)
+	// if no local track is received, the broadcaster will exit immediately
+	if len(localTracks) == 0 {
+		errorStream <- fmt.Errorf("runBroadcaster did not receive a track in %v. Exiting", waitOnTrackTimeout)
+		status, reason = utils.StatusFailed, fmt.Sprintf("no track received in %v", waitOnTrackTimeout)
+		tracksSpan.SetStatus(codes.Error, reason)
 		return
 	}
+	tracksSpan.End()
+
+	lgr.Logger.InfoContext(canxCtx, "runBroadcaster received remote tracks. Now I can accept participants")
+	tracks := []track{}
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
+		if localTrack, ok := localTracks[kind]; ok {
+			tracks = append(tracks, localTrack)
+		}
+	}
+
+	// Make the broadcast reachable from the HTTP endpoints
+	sessions.addBroadcast(broadcastID, requestCanxCtx, requestCanxFn, tracks, stats)
+	defer sessions.removeBroadcast(broadcastID)
+	activeBroadcasts.Add(canxCtx, 1, metricAttributes(broadcastID))
+	defer activeBroadcasts.Add(canxCtx, -1, metricAttributes(broadcastID))
+	signaling.SetStatus(canxCtx, errorStream, store, broadcastID, utils.StatusLive, "")
+
+	// Record the broadcast when requested
+	go watchRecording(canxCtx, requestCanxCtx, errorStream, store, sink, broadcastID, tracks)
 
 	// Monitor participant requests
-	participantReqStream := utils.MonitorRequests(canxCtx, requestCanxCtx, requestCanxFn, errorStream, db, "participant", broadcastReq.ID)
+	participantReqStream := store.WatchRequests(canxCtx, requestCanxCtx, errorStream, "participant", broadcastID)
 
 	// Wait to receive participant requests
 	for {
 		select {
 		case <-canxCtx.Done():
-			lgr.Logger.Info("startBroadcaster context cancelled")
+			lgr.Logger.InfoContext(canxCtx, "runBroadcaster context cancelled")
 			return
 		case <-requestCanxCtx.Done():
-			lgr.Logger.Info("startBroadcaster request context cancelled")
+			lgr.Logger.InfoContext(canxCtx, "runBroadcaster request context cancelled")
+			return
+		case participantReq, ok := <-participantReqStream:
+			if !ok {
+				lgr.Logger.InfoContext(canxCtx, "runBroadcaster participant request stream closed")
+				return
+			}
+
+			// Tell the participant why it is not answered when the meeting or the instance is full
+			if reason, ok := capacity.admitParticipant(broadcastID); !ok {
+				lgr.Logger.InfoContext(canxCtx, "runBroadcaster rejected a participant",
+					slog.String("broadcast", broadcastID),
+					slog.String("participant", participantReq.ID),
+					slog.String("reason", reason),
+				)
+				rejectParticipant(canxCtx, errorStream, store, participantReq.ID, reason)
+				continue
+			}
+
+			go func(participantID string) {
+				defer capacity.releaseParticipant(broadcastID)
+				startParticipant(canxCtx, requestCanxCtx, errorStream, store, broadcastID, participantID, tracks)
+			}(participantReq.ID)
+		}
+	}
+}
+
+// newBroadcasterConnection creates the broadcaster peer connection, applies the offer and
+// returns once the answer is ready i.e. the peer connection local description along with its stats.
+// Remote tracks are streamed to the local track stream and measured by the broadcast metrics.
+// If onCandidate is provided, local ICE candidates are trickled to it. Otherwise the answer
+// includes all candidates.
+func newBroadcasterConnection(canxCtx context.Context,
+	requestCanxCtx context.Context,
+	errorStream chan error,
+	offer webrtc.SessionDescription,
+	localTrackStream chan track,
+	metrics *mediaMetrics,
+	onCandidate func(candidate webrtc.ICECandidateInit)) (*webrtc.PeerConnection, *peerStats, error) {
+	m := &webrtc.MediaEngine{}
+	if err := m.RegisterDefaultCodecs(); err != nil {
+		return nil, nil, fmt.Errorf("RegisterDefaultCodecs error: %v", err)
+	}
+
+	// Create a InterceptorRegistry. This is the user configurable RTP/RTCP Pipeline.
+	// This provides NACKs, RTCP Reports and other features. If you use `webrtc.NewPeerConnection`
+	// this is enabled by default. If you are manually managing You MUST create a InterceptorRegistry
+	// for each PeerConnection.
+	i := &interceptor.Registry{}
+
+	// Use the default set of Interceptors
+	if err := webrtc.RegisterDefaultInterceptors(m, i); err != nil {
+		return nil, nil, fmt.Errorf("RegisterDefaultInterceptors error: %v", err)
+	}
+
+	// Record the RTP stream stats of the broadcaster
+	stats, err := newPeerStats(i)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// There is no interval PLI interceptor. Keyframes are requested only when participants
+	// ask for them (PLI/FIR) and these requests are forwarded to the broadcaster (see keyframeForwarder).
+	// NACKs from participants are answered locally by the participants' NACK responder interceptor.
+
+	// Share the ICE network settings (i.e. UDP mux) with all peer connections
+	se, err := ice.SettingEngine()
+	if err != nil {
+		return nil, nil, fmt.Errorf("SettingEngine error: %v", err)
+	}
+
+	// Create a new RTCPeerConnection
+	peerConnection, err := webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i), webrtc.WithSettingEngine(se)).NewPeerConnection(peerConnectionConfig())
+	if err != nil {
+		return nil, nil, fmt.Errorf("NewAPI error: %v", err)
+	}
+	stats.setPeerConnection(peerConnection)
+
+	// Close the peer connection if the negotiation fails
+	negotiated := false
+	defer func() {
+		if negotiated {
 			return
-		case participantReqDoc := <-participantReqStream:
-			go startParticipant(canxCtx, requestCanxCtx, errorStream, db, participantReqDoc.Ref, localTrack.Track)
 		}
+
+		if cErr := peerConnection.Close(); cErr != nil {
+			errorStream <- fmt.Errorf("newBroadcasterConnection cannot close peerConnection: %v", cErr)
+		}
+	}()
+
+	// Allow us to receive 1 video track and 1 audio track
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
+		if _, err = peerConnection.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{
+			Direction: webrtc.RTPTransceiverDirectionRecvonly,
+		}); err != nil {
+			return nil, nil, fmt.Errorf("AddTransceiverFromKind error: %v", err)
+		}
+	}
+
+	// Set a handler for when a new remote track starts, this just distributes all our packets
+	// to connected peers
+	peerConnection.OnTrack(func(remoteTrack *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		// WARNING: This happens once per remote track (i.e. audio and video) and arrives on its own goroutine
+		lgr.Logger.Info("newBroadcasterConnection peerConnection.OnTrack from the remote broadcaster",
+			slog.String("kind", remoteTrack.Kind().String()),
+		)
+		var keyframe *keyframeForwarder
+		if remoteTrack.Kind() == webrtc.RTPCodecTypeVideo {
+			keyframe = newKeyframeForwarder(requestCanxCtx, errorStream, peerConnection, remoteTrack, metrics)
+		}
+		go onRemoteTrack(canxCtx, requestCanxCtx, errorStream, localTrackStream, remoteTrack, receiver, keyframe, metrics)
+	})
+
+	connected := sync.Once{}
+	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateConnected {
+			connected.Do(func() {
+				metrics.Negotiated(canxCtx)
+			})
+		}
+	})
+
+	// Set the remote SessionDescription
+	err = peerConnection.SetRemoteDescription(offer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("SetRemoteDescription error: %v", err)
+	}
+
+	// Create answer
+	answer, err := peerConnection.CreateAnswer(nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("CreateAnswer error: %v", err)
+	}
+
+	// Trickle ICE candidates if the signaling supports it
+	// Otherwise create channel that is blocked until ICE Gathering is complete
+	var gatherComplete <-chan struct{}
+	if onCandidate != nil {
+		peerConnection.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+			// A nil candidate means gathering is complete
+			if candidate != nil {
+				onCandidate(candidate.ToJSON())
+			}
+		})
+	} else {
+		gatherComplete = webrtc.GatheringCompletePromise(peerConnection)
+	}
+
+	// Sets the LocalDescription, and starts our UDP listeners
+	err = peerConnection.SetLocalDescription(answer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("SetLocalDescription error: %v", err)
 	}
+
+	// Block until ICE Gathering is complete when not trickling
+	// we do this because we only can exchange one signaling message i.e. WHIP/WHEP
+	if gatherComplete != nil {
+		<-gatherComplete
+	}
+
+	negotiated = true
+	return peerConnection, stats, nil
 }
 
 func onRemoteTrack(canxCtx context.Context,
@@ -389,10 +519,12 @@ func onRemoteTrack(canxCtx context.Context,
 	errorStream chan error,
 	localTrackStream chan track,
 	remoteTrack *webrtc.TrackRemote,
-	_ *webrtc.RTPReceiver) {
-	lgr.Logger.Info("onRemoteTrack should happen only once")
-	// But I have noticed that it happens more than once
-	// So I am creating a child context for each track
+	_ *webrtc.RTPReceiver,
+	keyframe *keyframeForwarder,
+	metrics *mediaMetrics) {
+	lgr.Logger.Info("onRemoteTrack should happen only once per kind")
+	// But in case it happens more than once for the same kind
+	// I am creating a child context for each track
 	// this way I can cancel the track context when a new one arrives
 
 	// Get a child context for this track
@@ -400,17 +532,33 @@ func onRemoteTrack(canxCtx context.Context,
 	defer myCanxFn()
 
 	// Create a local track, all our SFU clients will be fed via this track
-	localTrack, newTrackErr := webrtc.NewTrackLocalStaticRTP(remoteTrack.Codec().RTPCodecCapability, "video", "family-meeting")
+	localTrack, newTrackErr := webrtc.NewTrackLocalStaticRTP(remoteTrack.Codec().RTPCodecCapability, remoteTrack.Kind().String(), localStreamID)
 	if newTrackErr != nil {
 		errorStream <- fmt.Errorf("onRemoteTrack NewTrackLocalStaticRTP error: %v", newTrackErr)
 		return
 	}
 
+	recorder := newTrackRecorder(remoteTrack.Codec(), remoteTrack.Kind())
+	ingress := newBitrateMeter()
+	received := metrics.Counter(remoteTrack.Kind().String(), rtpIn)
+
+	// H264 video is passed through to HLS for the passive viewers
+	var hls *hlsPackager
+	if keyframe != nil && strings.EqualFold(remoteTrack.Codec().MimeType, webrtc.MimeTypeH264) {
+		hls = newHLSPackager(keyframe.Request)
+	}
+
 	// Form a track object to stream to the localTrackStream
 	localTrackStream <- track{
-		Ctx:   myCanxCtx,
-		CtxFn: myCanxFn,
-		Track: localTrack,
+		Ctx:      myCanxCtx,
+		CtxFn:    myCanxFn,
+		Kind:     remoteTrack.Kind(),
+		Track:    localTrack,
+		Keyframe: keyframe,
+		Recorder: recorder,
+		HLS:      hls,
+		Ingress:  ingress,
+		Metrics:  metrics,
 	}
 
 	// Stream the incoming RTP packets to the local track
@@ -443,6 +591,7 @@ func onRemoteTrack(canxCtx context.Context,
 				return
 			case data := <-rtpStream:
 				if _, err := localTrack.Write(data); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+					metrics.Dropped(canxCtx, remoteTrack.Kind().String())
 					errorStream <- fmt.Errorf("rtpStreamer %p localTrack.Write error: %v", localTrack, err)
 					continue
 				}
@@ -476,6 +625,19 @@ func onRemoteTrack(canxCtx context.Context,
 				continue
 			}
 
+			ingress.Add(i)
+			received.Add(canxCtx, i)
+
+			if err := recorder.Write(rtpBuf[:i]); err != nil {
+				errorStream <- fmt.Errorf("onRemoteTrack %p recorder.Write error: %v", localTrack, err)
+			}
+
+			if hls != nil {
+				if err := hls.Write(rtpBuf[:i]); err != nil {
+					errorStream <- fmt.Errorf("onRemoteTrack %p hls.Write error: %v", localTrack, err)
+				}
+			}
+
 			// EXPERIMENTATION:
 			// Separate reading RTP packets from writing RTP packets to local track
 			// Hopefully this will solve pixalation issues at the peers
@@ -486,6 +648,7 @@ func onRemoteTrack(canxCtx context.Context,
 
 			// ErrClosedPipe means we don't have any subscribers, this is ok if no peers have connected yet
 			if _, err := localTrack.Write(rtpBuf[:i]); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+				metrics.Dropped(canxCtx, remoteTrack.Kind().String())
 				errorStream <- fmt.Errorf("onRemoteTrack %p localTrack.Write error: %v", localTrack, err)
 				continue
 			}
@@ -496,100 +659,316 @@ func onRemoteTrack(canxCtx context.Context,
 func startParticipant(canxCtx context.Context,
 	requestCanxCtx context.Context,
 	errorStream chan error,
-	db *firestore.Client,
-	participantReq *firestore.DocumentRef,
-	localTrack *webrtc.TrackLocalStaticRTP) {
-	participantOffer := utils.WaitForOffer(canxCtx, requestCanxCtx, errorStream, db, participantReq)
-	lgr.Logger.Info("startParticipant received offer from a participant")
-	if localTrack == nil {
-		errorStream <- fmt.Errorf("startParticipant localTrack is nil. Exiting")
+	store signaling.SignalingStore,
+	broadcastID string,
+	participantID string,
+	localTracks []track) {
+	// The participant join is traced as part of the broadcast. The span ends once the
+	// participant is answered or fails to be.
+	canxCtx, span := tracer.Start(canxCtx, "startParticipant",
+		trace.WithAttributes(
+			attribute.String("broadcast", broadcastID),
+			attribute.String("participant", participantID),
+		),
+	)
+
+	participantOffer := signaling.WaitForOffer(canxCtx, requestCanxCtx, errorStream, store, participantID)
+	lgr.Logger.InfoContext(canxCtx, "startParticipant received offer from a participant")
+	if len(localTracks) == 0 {
+		errorStream <- fmt.Errorf("startParticipant localTracks is empty. Exiting")
+		span.SetStatus(codes.Error, "no local tracks")
+		span.End()
 		return
 	}
 
-	// Create a new PeerConnection
-	peerConnection, err := webrtc.NewPeerConnection(peerConnectionConfig)
+	// Get a child context for this participant so it can be torn down on its own
+	participantCanxCtx, participantCanxFn := context.WithCancel(requestCanxCtx)
+	defer participantCanxFn()
+
+	peerConnection, stats, err := newParticipantConnection(canxCtx, participantCanxCtx, participantCanxFn, errorStream, participantOffer, localTracks,
+		trickleCandidate(canxCtx, errorStream, store, participantID))
 	if err != nil {
-		errorStream <- fmt.Errorf("startParticipant NewPeerConnection error: %v", err)
+		failSpan(span, err)
+		span.End()
+		errorStream <- fmt.Errorf("startParticipant %v", err)
 		return
 	}
+	defer func() {
+		if cErr := peerConnection.Close(); cErr != nil {
+			errorStream <- fmt.Errorf("startParticipant cannot close peerConnection: %v", cErr)
+		}
+	}()
 
-	rtpSender, err := peerConnection.AddTrack(localTrack)
+	// Update the answer in the participant request
+	err = store.SetAnswer(canxCtx, participantID, utils.Encode(peerConnection.LocalDescription()))
 	if err != nil {
-		errorStream <- fmt.Errorf("startParticipant AddTrack error: %v", err)
+		failSpan(span, err)
+		span.End()
+		errorStream <- fmt.Errorf("startParticipant store.SetAnswer error: %v", err)
 		return
 	}
 
-	// Read incoming RTCP packets
-	// Before these packets are returned they are processed by interceptors. For things
-	// like NACK this needs to be called.
-	go func() {
-		rtcpBuf := make([]byte, 1500)
-		for {
-			select {
-			case <-canxCtx.Done():
-				lgr.Logger.Info("startParticipant RTCP context cancelled")
-				return
-			case <-requestCanxCtx.Done():
-				lgr.Logger.Info("startParticipant RTCP request context cancelled")
-				return
-			default:
-				if _, _, rtcpErr := rtpSender.Read(rtcpBuf); rtcpErr != nil {
-					errorStream <- fmt.Errorf("startParticipant RTCP rtpSender.Read error: %v", err)
-					return
+	// Apply the candidates trickled by the participant
+	go addRemoteCandidates(canxCtx, participantCanxCtx, errorStream, store, participantID, peerConnection)
+
+	// The participant has joined once answered
+	span.End()
+
+	runParticipant(canxCtx, participantCanxCtx, participantCanxFn, broadcastID, participantID, signalingParticipant, stats)
+}
+
+// rejectParticipant writes the rejection reason to the participant request
+func rejectParticipant(canxCtx context.Context,
+	errorStream chan error,
+	store signaling.SignalingStore,
+	participantID string,
+	reason string) {
+	err := store.UpdateRequest(canxCtx, participantID, map[string]interface{}{
+		"rejection": reason,
+	})
+	if err != nil {
+		errorStream <- fmt.Errorf("rejectParticipant store.UpdateRequest error: %v", err)
+	}
+}
+
+// runParticipant registers the participant and waits until it is cancelled
+func runParticipant(canxCtx context.Context,
+	participantCanxCtx context.Context,
+	participantCanxFn context.CancelFunc,
+	broadcastID string,
+	participantID string,
+	kind string,
+	stats *peerStats) {
+	sessions.addParticipant(broadcastID, participantID, kind, participantCanxFn, stats)
+	defer sessions.removeParticipant(broadcastID, participantID)
+	activeParticipants.Add(canxCtx, 1, metricAttributes(broadcastID))
+	defer activeParticipants.Add(canxCtx, -1, metricAttributes(broadcastID))
+
+	select {
+	case <-canxCtx.Done():
+		lgr.Logger.InfoContext(canxCtx, "runParticipant context cancelled")
+	case <-participantCanxCtx.Done():
+		lgr.Logger.InfoContext(canxCtx, "runParticipant participant context cancelled",
+			slog.String("participant", participantID),
+		)
+	}
+}
+
+// newParticipantConnection creates the participant peer connection fed from the broadcaster
+// local tracks, applies the offer and returns once the answer is ready along with the peer
+// connection stats. The participant context is cancelled if the connection fails or closes. If onCandidate is provided, local
+// ICE candidates are trickled to it. Otherwise the answer includes all candidates.
+func newParticipantConnection(canxCtx context.Context,
+	participantCanxCtx context.Context,
+	participantCanxFn context.CancelFunc,
+	errorStream chan error,
+	offer webrtc.SessionDescription,
+	localTracks []track,
+	onCandidate func(candidate webrtc.ICECandidateInit)) (*webrtc.PeerConnection, *peerStats, error) {
+	// Same as `webrtc.NewPeerConnection` but with the shared ICE network settings (i.e. UDP mux)
+	m := &webrtc.MediaEngine{}
+	if err := m.RegisterDefaultCodecs(); err != nil {
+		return nil, nil, fmt.Errorf("RegisterDefaultCodecs error: %v", err)
+	}
+
+	i := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(m, i); err != nil {
+		return nil, nil, fmt.Errorf("RegisterDefaultInterceptors error: %v", err)
+	}
+
+	stats, err := newPeerStats(i)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// All local tracks share the broadcast metrics
+	start := time.Now()
+	var metrics *mediaMetrics
+	if len(localTracks) > 0 {
+		metrics = localTracks[0].Metrics
+		i.Add(&rtpOutFactory{metrics: metrics})
+	}
+
+	se, err := ice.SettingEngine()
+	if err != nil {
+		return nil, nil, fmt.Errorf("SettingEngine error: %v", err)
+	}
+
+	// Create a new PeerConnection
+	peerConnection, err := webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i), webrtc.WithSettingEngine(se)).NewPeerConnection(peerConnectionConfig())
+	if err != nil {
+		return nil, nil, fmt.Errorf("NewPeerConnection error: %v", err)
+	}
+	stats.setPeerConnection(peerConnection)
+
+	// Close the peer connection if the negotiation fails
+	negotiated := false
+	defer func() {
+		if negotiated {
+			return
+		}
+
+		if cErr := peerConnection.Close(); cErr != nil {
+			errorStream <- fmt.Errorf("newParticipantConnection cannot close peerConnection: %v", cErr)
+		}
+	}()
+
+	connected := sync.Once{}
+	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			if metrics != nil {
+				connected.Do(func() {
+					metrics.ParticipantNegotiated(canxCtx, start)
+				})
+			}
+
+			// Ask for a keyframe so a late joiner does not wait for the next one
+			for _, localTrack := range localTracks {
+				if localTrack.Keyframe != nil {
+					localTrack.Keyframe.Request()
 				}
 			}
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			participantCanxFn()
+		}
+	})
+
+	for _, localTrack := range localTracks {
+		rtpSender, err := peerConnection.AddTrack(localTrack.Track)
+		if err != nil {
+			return nil, nil, fmt.Errorf("AddTrack error: %v", err)
 		}
-	}()
+
+		// Read incoming RTCP packets
+		// Before these packets are returned they are processed by interceptors. For things
+		// like NACK this needs to be called.
+		// Keyframe requests (PLI/FIR) are forwarded to the broadcaster.
+		go func() {
+			for {
+				select {
+				case <-canxCtx.Done():
+					lgr.Logger.Info("newParticipantConnection RTCP context cancelled")
+					return
+				case <-participantCanxCtx.Done():
+					lgr.Logger.Info("newParticipantConnection RTCP participant context cancelled")
+					return
+				default:
+					packets, _, rtcpErr := rtpSender.ReadRTCP()
+					if rtcpErr != nil {
+						if participantCanxCtx.Err() == nil && !errors.Is(rtcpErr, io.EOF) {
+							errorStream <- fmt.Errorf("newParticipantConnection RTCP rtpSender.ReadRTCP error: %v", rtcpErr)
+						}
+						return
+					}
+
+					if localTrack.Keyframe != nil && isKeyframeRequest(packets) {
+						localTrack.Keyframe.Request()
+					}
+				}
+			}
+		}()
+	}
 
 	// Set the remote SessionDescription
-	err = peerConnection.SetRemoteDescription(participantOffer)
+	err = peerConnection.SetRemoteDescription(offer)
 	if err != nil {
-		errorStream <- fmt.Errorf("startParticipant SetRemoteDescription error: %v", err)
-		return
+		return nil, nil, fmt.Errorf("SetRemoteDescription error: %v", err)
 	}
 
 	// Create answer
 	answer, err := peerConnection.CreateAnswer(nil)
 	if err != nil {
-		errorStream <- fmt.Errorf("startParticipant CreateAnswer error: %v", err)
-		return
+		return nil, nil, fmt.Errorf("CreateAnswer error: %v", err)
 	}
 
-	// Create channel that is blocked until ICE Gathering is complete
-	gatherComplete := webrtc.GatheringCompletePromise(peerConnection)
+	// Trickle ICE candidates if the signaling supports it
+	// Otherwise create channel that is blocked until ICE Gathering is complete
+	var gatherComplete <-chan struct{}
+	if onCandidate != nil {
+		peerConnection.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+			// A nil candidate means gathering is complete
+			if candidate != nil {
+				onCandidate(candidate.ToJSON())
+			}
+		})
+	} else {
+		gatherComplete = webrtc.GatheringCompletePromise(peerConnection)
+	}
 
 	// Sets the LocalDescription, and starts our UDP listeners
 	err = peerConnection.SetLocalDescription(answer)
 	if err != nil {
-		errorStream <- fmt.Errorf("startParticipant SetLocalDescription error: %v", err)
-		return
+		return nil, nil, fmt.Errorf("SetLocalDescription error: %v", err)
 	}
 
-	// Block until ICE Gathering is complete, disabling trickle ICE
-	// we do this because we only can exchange one signaling message
-	// in a production application you should exchange ICE Candidates via OnICECandidate
-	<-gatherComplete
+	// Block until ICE Gathering is complete when not trickling
+	// we do this because we only can exchange one signaling message i.e. WHIP/WHEP
+	if gatherComplete != nil {
+		<-gatherComplete
+	}
 
-	// Update the answer in the participant request
-	_, err = participantReq.Update(canxCtx, []firestore.Update{
-		{
-			Path:  "answer",
-			Value: utils.Encode(peerConnection.LocalDescription()),
-		},
-	})
+	negotiated = true
+	return peerConnection, stats, nil
+}
+
+// failSpan records the error on the span and marks it as failed
+func failSpan(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// expectedTracks returns the number of audio and video tracks the offer sends
+func expectedTracks(offer webrtc.SessionDescription) int {
+	parsed, err := offer.Unmarshal()
 	if err != nil {
-		errorStream <- fmt.Errorf("startParticipant participantReq.Update error: %v", err)
-		return
+		return 1
 	}
 
-	for {
-		select {
-		case <-canxCtx.Done():
-			lgr.Logger.Info("startParticipant context cancelled")
-			return
-		case <-requestCanxCtx.Done():
-			lgr.Logger.Info("startParticipant request context cancelled")
-			return
+	count := 0
+	for _, media := range parsed.MediaDescriptions {
+		if media.MediaName.Media != webrtc.RTPCodecTypeAudio.String() && media.MediaName.Media != webrtc.RTPCodecTypeVideo.String() {
+			continue
+		}
+
+		_, recvonly := media.Attribute(webrtc.RTPTransceiverDirectionRecvonly.String())
+		_, inactive := media.Attribute(webrtc.RTPTransceiverDirectionInactive.String())
+		if recvonly || inactive {
+			continue
+		}
+
+		count++
+	}
+
+	// Since we receive at most 1 audio and 1 video tracks
+	return min(count, 2)
+}
+
+// trickleCandidate returns a callback that writes local ICE candidates to the request answer candidates
+func trickleCandidate(canxCtx context.Context,
+	errorStream chan error,
+	store signaling.SignalingStore,
+	id string) func(candidate webrtc.ICECandidateInit) {
+	return func(candidate webrtc.ICECandidateInit) {
+		err := store.AddCandidate(canxCtx, id, utils.AnswerCandidates, utils.EncodeCandidate(&candidate))
+		if err != nil {
+			errorStream <- fmt.Errorf("trickleCandidate store.AddCandidate error: %v", err)
+		}
+	}
+}
+
+// addRemoteCandidates applies the ICE candidates trickled on the request offer candidates
+// until the context(s) are cancelled
+func addRemoteCandidates(canxCtx context.Context,
+	requestCanxCtx context.Context,
+	errorStream chan error,
+	store signaling.SignalingStore,
+	id string,
+	peerConnection *webrtc.PeerConnection) {
+	for candidate := range signaling.WatchCandidates(canxCtx, requestCanxCtx, errorStream, store, id, utils.OfferCandidates) {
+		err := peerConnection.AddICECandidate(candidate)
+		if err != nil {
+			errorStream <- fmt.Errorf("addRemoteCandidates AddICECandidate error: %v", err)
 		}
 	}
 }
@@ -0,0 +1,91 @@
+package broadcast
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// offerSDP returns an offer with one media section per direction (e.g. "audio sendonly")
+func offerSDP(medias ...string) string {
+	sdp := []string{
+		"v=0",
+		"o=- 0 0 IN IP4 127.0.0.1",
+		"s=-",
+		"t=0 0",
+	}
+
+	for _, media := range medias {
+		fields := strings.Fields(media)
+		sdp = append(sdp,
+			"m="+fields[0]+" 9 UDP/TLS/RTP/SAVPF 96",
+			"c=IN IP4 0.0.0.0",
+			"a="+fields[1],
+		)
+	}
+
+	return strings.Join(sdp, "\r\n") + "\r\n"
+}
+
+func TestExpectedTracks(t *testing.T) {
+	tests := []struct {
+		name   string
+		sdp    string
+		tracks int
+	}{
+		{
+			name:   "video",
+			sdp:    offerSDP("video sendonly"),
+			tracks: 1,
+		},
+		{
+			name:   "audio and video",
+			sdp:    offerSDP("audio sendonly", "video sendonly"),
+			tracks: 2,
+		},
+		{
+			name:   "sendrecv",
+			sdp:    offerSDP("audio sendrecv", "video sendrecv"),
+			tracks: 2,
+		},
+		{
+			name:   "recvonly and inactive are not received",
+			sdp:    offerSDP("audio recvonly", "video inactive", "video sendonly"),
+			tracks: 1,
+		},
+		{
+			name:   "data channel is not a track",
+			sdp:    offerSDP("application sendrecv", "video sendonly"),
+			tracks: 1,
+		},
+		{
+			name:   "at most one audio and one video",
+			sdp:    offerSDP("audio sendonly", "video sendonly", "video sendonly"),
+			tracks: 2,
+		},
+		{
+			name:   "no media",
+			sdp:    offerSDP(),
+			tracks: 0,
+		},
+		{
+			name:   "malformed",
+			sdp:    "not an sdp",
+			tracks: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			offer := webrtc.SessionDescription{
+				Type: webrtc.SDPTypeOffer,
+				SDP:  test.sdp,
+			}
+
+			if got := expectedTracks(offer); got != test.tracks {
+				t.Fatalf("got %d tracks, want %d", got, test.tracks)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package broadcast
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+
+	"github.com/khaledhikmat/family-meeting/service/dispatch"
+	"github.com/khaledhikmat/family-meeting/service/lgr"
+	"github.com/khaledhikmat/family-meeting/service/signaling"
+	"github.com/khaledhikmat/family-meeting/utils"
+)
+
+const (
+	maxBroadcastsKey    = "MAX_BROADCASTS"
+	maxParticipantsKey  = "MAX_PARTICIPANTS"
+	maxEgressBitrateKey = "MAX_EGRESS_BITRATE"
+
+	// Written to the participant request so the client can tell why it was not answered
+	rejectMeetingFull  = "meeting full"
+	rejectInstanceFull = "meeting full: no bandwidth left on this instance"
+
+	// A broadcast message is released this many times before the broadcast fails. The memory
+	// dispatcher keeps redelivering to the same instance so it would never be picked up.
+	maxCapacityAttempts = 10
+	failNoCapacity      = "no instance has capacity"
+	// Pub/Sub only counts the deliveries when the subscription has a dead letter policy.
+	// Otherwise, the broadcast fails once it was requested this long ago.
+	maxCapacityWait = 1 * time.Minute
+
+	bitrateWindow = 1 * time.Second
+)
+
+// limits are the capacity of this instance. Zero means unlimited.
+type limits struct {
+	Broadcasts   int
+	Participants int
+	// Estimated bits per second sent to all participants
+	EgressBitrate int64
+}
+
+// admission reserves capacity for broadcasters and participants before they are started so
+// concurrent requests cannot overshoot the limits
+type admission struct {
+	mutex        sync.Mutex
+	limits       limits
+	broadcasts   int
+	participants map[string]int
+}
+
+var capacity = &admission{
+	participants: map[string]int{},
+}
+
+func init() {
+	capacity.limits = limits{
+		Broadcasts:    int(envLimit(maxBroadcastsKey)),
+		Participants:  int(envLimit(maxParticipantsKey)),
+		EgressBitrate: envLimit(maxEgressBitrateKey),
+	}
+}
+
+func envLimit(key string) int64 {
+	if os.Getenv(key) == "" {
+		return 0
+	}
+
+	limit, err := strconv.ParseInt(os.Getenv(key), 10, 64)
+	if err != nil || limit < 0 {
+		lgr.Logger.Error(
+			"parsing capacity limit",
+			slog.String("key", key),
+			slog.Any("error", xerrors.New("invalid limit: "+os.Getenv(key))),
+		)
+		return 0
+	}
+
+	return limit
+}
+
+// admitBroadcast reserves a broadcast slot. It must be released when the broadcast ends.
+func (a *admission) admitBroadcast() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.limits.Broadcasts > 0 && a.broadcasts >= a.limits.Broadcasts {
+		return false
+	}
+
+	a.broadcasts++
+	return true
+}
+
+func (a *admission) releaseBroadcast() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.broadcasts--
+}
+
+// admitParticipant reserves a participant slot in the broadcast. It must be released when the
+// participant leaves. Otherwise, it returns the reason the participant is rejected.
+// The egress is estimated as the broadcaster ingress bitrate times its participants.
+func (a *admission) admitParticipant(broadcastID string) (string, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.limits.Participants > 0 && a.participants[broadcastID] >= a.limits.Participants {
+		return rejectMeetingFull, false
+	}
+
+	if a.limits.EgressBitrate > 0 {
+		egress := sessions.ingressBitrate(broadcastID)
+		for id, count := range a.participants {
+			egress += sessions.ingressBitrate(id) * int64(count)
+		}
+
+		if egress > a.limits.EgressBitrate {
+			return rejectInstanceFull, false
+		}
+	}
+
+	a.participants[broadcastID]++
+	return "", true
+}
+
+func (a *admission) releaseParticipant(broadcastID string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.participants[broadcastID]--
+	if a.participants[broadcastID] <= 0 {
+		delete(a.participants, broadcastID)
+	}
+}
+
+// bitrateMeter measures the bitrate of a track over the last window
+type bitrateMeter struct {
+	mutex       sync.Mutex
+	bytes       int64
+	windowStart time.Time
+	bitrate     int64
+}
+
+func newBitrateMeter() *bitrateMeter {
+	return &bitrateMeter{
+		windowStart: time.Now(),
+	}
+}
+
+// Add counts the bytes of a received packet
+func (m *bitrateMeter) Add(n int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	if elapsed := now.Sub(m.windowStart); elapsed >= bitrateWindow {
+		m.bitrate = m.bytes * 8 * int64(time.Second) / int64(elapsed)
+		m.bytes = 0
+		m.windowStart = now
+	}
+
+	m.bytes += int64(n)
+}
+
+// Bitrate returns the bits per second of the last window. It is zero if the track stalled.
+func (m *bitrateMeter) Bitrate() int64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if time.Since(m.windowStart) >= 2*bitrateWindow {
+		return 0
+	}
+
+	return m.bitrate
+}
+
+// capacityExhausted tells whether a broadcast message no instance admitted should fail the broadcast.
+// The delivery attempts are used when known. Otherwise, the time since the broadcast was requested
+// (or dispatched for older clients) is used.
+func capacityExhausted(canxCtx context.Context, store signaling.SignalingStore, msg *dispatch.Message) bool {
+	if msg.DeliveryAttempt > 0 {
+		return msg.DeliveryAttempt >= maxCapacityAttempts
+	}
+
+	request, err := store.GetRequest(canxCtx, string(msg.Data))
+	if err != nil {
+		return false
+	}
+
+	for _, transition := range request.Transitions {
+		if transition.Status == utils.StatusRequested || transition.Status == utils.StatusDispatched {
+			return time.Since(transition.At) >= maxCapacityWait
+		}
+	}
+
+	return false
+}
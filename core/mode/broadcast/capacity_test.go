@@ -0,0 +1,92 @@
+package broadcast
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/khaledhikmat/family-meeting/service/dispatch"
+	"github.com/khaledhikmat/family-meeting/service/signaling"
+	"github.com/khaledhikmat/family-meeting/utils"
+)
+
+func TestCapacityExhausted(t *testing.T) {
+	transitions := func(status string, ago time.Duration) []utils.Transition {
+		return []utils.Transition{
+			{Status: status, At: time.Now().Add(-ago)},
+			{Status: utils.StatusDispatched, At: time.Now()},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		attempt     int
+		transitions []utils.Transition
+		missing     bool
+		exhausted   bool
+	}{
+		{
+			name:    "first delivery",
+			attempt: 1,
+		},
+		{
+			name:      "last delivery",
+			attempt:   maxCapacityAttempts,
+			exhausted: true,
+		},
+		{
+			name:        "attempts are used when known",
+			attempt:     2,
+			transitions: transitions(utils.StatusRequested, 2*maxCapacityWait),
+		},
+		{
+			name:        "recently requested",
+			transitions: transitions(utils.StatusRequested, maxCapacityWait/2),
+		},
+		{
+			name:        "requested long ago",
+			transitions: transitions(utils.StatusRequested, 2*maxCapacityWait),
+			exhausted:   true,
+		},
+		{
+			name:        "dispatched long ago",
+			transitions: transitions(utils.StatusDispatched, 2*maxCapacityWait),
+			exhausted:   true,
+		},
+		{
+			name: "no transitions",
+		},
+		{
+			name:    "missing request",
+			missing: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store, err := signaling.NewMemory(context.Background())
+			if err != nil {
+				t.Fatalf("NewMemory error: %v", err)
+			}
+
+			id := "missing"
+			if !test.missing {
+				id, err = store.CreateRequest(context.Background(), utils.Request{
+					Kind:        "broadcaster",
+					Transitions: test.transitions,
+				})
+				if err != nil {
+					t.Fatalf("CreateRequest error: %v", err)
+				}
+			}
+
+			msg := &dispatch.Message{
+				Data:            []byte(id),
+				DeliveryAttempt: test.attempt,
+			}
+			if exhausted := capacityExhausted(context.Background(), store, msg); exhausted != test.exhausted {
+				t.Fatalf("got exhausted %v, want %v", exhausted, test.exhausted)
+			}
+		})
+	}
+}
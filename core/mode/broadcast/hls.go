@@ -0,0 +1,333 @@
+package broadcast
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v4/pkg/media/samplebuilder"
+)
+
+const (
+	hlsSegmentDuration = 2 * time.Second
+	hlsPlaylistSize    = 6
+	// Segments dropped from the playlist are kept a little longer for players still fetching them
+	hlsRetainedSegments = hlsPlaylistSize + 2
+	hlsSampleMaxLate    = 256
+	hlsPlaylistName     = "index.m3u8"
+	hlsSegmentExt       = ".ts"
+	// The H264 RTP clock rate is the MPEG-TS clock rate so RTP timestamps are used as is
+	h264ClockRate = 90000
+	// Start timestamps at 1 second so they never go negative
+	hlsPTSOffset = h264ClockRate
+
+	h264NALTypeIDR = 5
+	h264NALTypeSPS = 7
+	h264NALTypePPS = 8
+)
+
+type hlsSegment struct {
+	Seq      int
+	Duration time.Duration
+	Data     []byte
+}
+
+// hlsPackager packages the broadcaster H264 video (passthrough) into MPEG-TS segments and
+// keeps a sliding window of them for HLS players. Segments start on keyframes.
+type hlsPackager struct {
+	requestKeyframe func()
+
+	mutex    sync.Mutex
+	builder  *samplebuilder.SampleBuilder
+	muxer    *tsMuxer
+	segments []hlsSegment
+	nextSeq  int
+
+	current           *bytes.Buffer
+	currentStart      int64
+	keyframeRequested bool
+
+	started bool
+	lastTS  uint32
+	lastDTS int64
+
+	// Latest parameter sets to make sure every segment can be decoded alone
+	params h264Params
+}
+
+// h264Params keeps the latest H264 parameter sets seen in the stream
+type h264Params struct {
+	sps []byte
+	pps []byte
+}
+
+func newHLSPackager(requestKeyframe func()) *hlsPackager {
+	return &hlsPackager{
+		requestKeyframe: requestKeyframe,
+		builder:         samplebuilder.New(hlsSampleMaxLate, &codecs.H264Packet{}, h264ClockRate),
+		muxer:           newTSMuxer(),
+	}
+}
+
+// Write packages the RTP packet
+func (p *hlsPackager) Write(buf []byte) error {
+	// The sample builder holds on to the packets so they cannot share the read buffer
+	packet := &rtp.Packet{}
+	err := packet.Unmarshal(append([]byte(nil), buf...))
+	if err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.builder.Push(packet)
+	for sample := p.builder.Pop(); sample != nil; sample = p.builder.Pop() {
+		// The RTP timestamps are presentation times and WebRTC streams have no B-frames
+		p.writeAccessUnit(sample.Data, sample.PacketTimestamp, sample.PacketTimestamp)
+	}
+
+	return nil
+}
+
+// WriteAccessUnit packages an Annex-B access unit of a source which knows its decoding time
+// i.e. an RTMP publisher with B-frames. The timestamps are in the 90kHz clock.
+func (p *hlsPackager) WriteAccessUnit(data []byte, pts uint32, dts uint32) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.writeAccessUnit(data, pts, dts)
+}
+
+// Playlist returns the live playlist. It is not available until the first segment is complete.
+func (p *hlsPackager) Playlist() (string, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(p.segments) == 0 {
+		return "", false
+	}
+
+	window := p.segments
+	if len(window) > hlsPlaylistSize {
+		window = window[len(window)-hlsPlaylistSize:]
+	}
+
+	target := 0
+	for _, segment := range window {
+		target = max(target, int(math.Ceil(segment.Duration.Seconds())))
+	}
+
+	b := &strings.Builder{}
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	fmt.Fprintf(b, "#EXT-X-TARGETDURATION:%d\n", target)
+	fmt.Fprintf(b, "#EXT-X-MEDIA-SEQUENCE:%d\n", window[0].Seq)
+	for _, segment := range window {
+		fmt.Fprintf(b, "#EXTINF:%.3f,\n", segment.Duration.Seconds())
+		fmt.Fprintf(b, "%d%s\n", segment.Seq, hlsSegmentExt)
+	}
+
+	return b.String(), true
+}
+
+// Segment returns a retained segment by sequence number
+func (p *hlsPackager) Segment(seq int) ([]byte, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, segment := range p.segments {
+		if segment.Seq == seq {
+			return segment.Data, true
+		}
+	}
+
+	return nil, false
+}
+
+func (p *hlsPackager) writeAccessUnit(data []byte, ptsTS uint32, dtsTS uint32) {
+	// Unwrap the 32-bit decoding timestamps. They always increase unlike the presentation ones.
+	if !p.started {
+		p.started = true
+		p.lastDTS = hlsPTSOffset
+	} else {
+		p.lastDTS += int64(int32(dtsTS - p.lastTS))
+	}
+	p.lastTS = dtsTS
+	dts := p.lastDTS
+	pts := dts + int64(int32(ptsTS-dtsTS))
+
+	au, keyframe := p.params.accessUnit(data)
+	if p.current == nil && !keyframe {
+		p.askForKeyframe()
+		return
+	}
+
+	elapsed := dts - p.currentStart
+	if p.current != nil && keyframe && elapsed >= int64(hlsSegmentDuration.Seconds()*h264ClockRate) {
+		p.closeSegment(elapsed)
+	}
+
+	if p.current == nil {
+		p.current = &bytes.Buffer{}
+		p.currentStart = dts
+		p.keyframeRequested = false
+		p.muxer.WriteTables(p.current)
+	} else if elapsed >= int64(hlsSegmentDuration.Seconds()*h264ClockRate) {
+		// Browsers only send keyframes when asked
+		p.askForKeyframe()
+	}
+
+	p.muxer.WriteVideo(p.current, au, pts, dts, keyframe)
+}
+
+func (p *hlsPackager) closeSegment(elapsed int64) {
+	p.segments = append(p.segments, hlsSegment{
+		Seq:      p.nextSeq,
+		Duration: time.Duration(elapsed) * time.Second / h264ClockRate,
+		Data:     p.current.Bytes(),
+	})
+	p.nextSeq++
+	p.current = nil
+
+	if len(p.segments) > hlsRetainedSegments {
+		p.segments = p.segments[len(p.segments)-hlsRetainedSegments:]
+	}
+}
+
+func (p *hlsPackager) askForKeyframe() {
+	if p.keyframeRequested || p.requestKeyframe == nil {
+		return
+	}
+
+	p.keyframeRequested = true
+	p.requestKeyframe()
+}
+
+// accessUnit rebuilds the Annex-B access unit making sure keyframes carry the parameter sets
+func (p *h264Params) accessUnit(data []byte) ([]byte, bool) {
+	nals := splitAnnexB(data)
+
+	keyframe, hasSPS, hasPPS := false, false, false
+	for _, nal := range nals {
+		switch nal[0] & 0x1f {
+		case h264NALTypeIDR:
+			keyframe = true
+		case h264NALTypeSPS:
+			hasSPS = true
+			p.sps = append([]byte(nil), nal...)
+		case h264NALTypePPS:
+			hasPPS = true
+			p.pps = append([]byte(nil), nal...)
+		}
+	}
+
+	au := make([]byte, 0, len(data)+len(p.sps)+len(p.pps)+8)
+	if keyframe && !hasSPS && p.sps != nil {
+		au = append(au, 0x00, 0x00, 0x00, 0x01)
+		au = append(au, p.sps...)
+	}
+	if keyframe && !hasPPS && p.pps != nil {
+		au = append(au, 0x00, 0x00, 0x00, 0x01)
+		au = append(au, p.pps...)
+	}
+	for _, nal := range nals {
+		au = append(au, 0x00, 0x00, 0x00, 0x01)
+		au = append(au, nal...)
+	}
+
+	return au, keyframe
+}
+
+// splitAnnexB returns the NAL units without their start codes
+func splitAnnexB(data []byte) [][]byte {
+	nals := [][]byte{}
+	start := -1
+	for i := 0; i+2 < len(data); i++ {
+		if data[i] != 0 || data[i+1] != 0 || data[i+2] != 1 {
+			continue
+		}
+
+		if start >= 0 {
+			nals = appendNAL(nals, data[start:i])
+		}
+		start = i + 3
+		i += 2
+	}
+
+	if start >= 0 && start < len(data) {
+		nals = appendNAL(nals, data[start:])
+	}
+
+	return nals
+}
+
+func appendNAL(nals [][]byte, nal []byte) [][]byte {
+	// Drop the trailing zero of 4-byte start codes
+	nal = bytes.TrimRight(nal, "\x00")
+	if len(nal) == 0 {
+		return nals
+	}
+
+	return append(nals, nal)
+}
+
+// hlsHandler serves the playlist and the segments of a live broadcast served by this instance
+func hlsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowCORS(c)
+
+		broadcast, ok := sessions.getBroadcast(c.Param("id"))
+		if !ok {
+			c.String(http.StatusNotFound, "broadcast not found")
+			return
+		}
+
+		var packager *hlsPackager
+		for _, t := range broadcast.Tracks {
+			if t.HLS != nil {
+				packager = t.HLS
+			}
+		}
+
+		if packager == nil {
+			c.String(http.StatusNotFound, "broadcast video is not H264")
+			return
+		}
+
+		file := c.Param("file")
+		if file == hlsPlaylistName {
+			playlist, ok := packager.Playlist()
+			if !ok {
+				c.String(http.StatusNotFound, "playlist not ready")
+				return
+			}
+
+			c.Header("Cache-Control", "no-cache")
+			c.Data(http.StatusOK, "application/vnd.apple.mpegurl", []byte(playlist))
+			return
+		}
+
+		seq, err := strconv.Atoi(strings.TrimSuffix(file, hlsSegmentExt))
+		if err != nil || !strings.HasSuffix(file, hlsSegmentExt) {
+			c.String(http.StatusNotFound, "segment not found")
+			return
+		}
+
+		segment, ok := packager.Segment(seq)
+		if !ok {
+			c.String(http.StatusNotFound, "segment not found")
+			return
+		}
+
+		c.Data(http.StatusOK, "video/mp2t", segment)
+	}
+}
@@ -0,0 +1,258 @@
+package broadcast
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+const testFrameTicks = h264ClockRate / 30
+
+// h264Frame returns the RTP packets (one per NAL unit) of a keyframe or a predicted frame
+func h264Frame(seq *uint16, ts uint32, keyframe bool) [][]byte {
+	nals := [][]byte{{0x41, 0x9a}}
+	if keyframe {
+		nals = [][]byte{{0x67, 0x42, 0xc0, 0x1f}, {0x68, 0xce}, {0x65, 0x88}}
+	}
+
+	packets := [][]byte{}
+	for i, nal := range nals {
+		packet := &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				PayloadType:    96,
+				SequenceNumber: *seq,
+				Timestamp:      ts,
+				Marker:         i == len(nals)-1,
+			},
+			Payload: nal,
+		}
+		*seq++
+
+		b, _ := packet.Marshal()
+		packets = append(packets, b)
+	}
+
+	return packets
+}
+
+func TestHLSPackagerSegments(t *testing.T) {
+	tests := []struct {
+		name string
+		// Every frame is a keyframe in the interval (in frames) from the leading frame on
+		keyframeInterval int
+		leading          int
+		frames           int
+		durations        []time.Duration
+		keyframeRequests int
+	}{
+		{
+			name:             "segments close on the first keyframe after 2s",
+			keyframeInterval: 30,
+			frames:           6*30 + 1,
+			durations:        []time.Duration{2 * time.Second, 2 * time.Second, 2 * time.Second},
+		},
+		{
+			name:             "segments wait for a late keyframe",
+			keyframeInterval: 75,
+			frames:           2*75 + 1,
+			durations:        []time.Duration{2500 * time.Millisecond, 2500 * time.Millisecond},
+			keyframeRequests: 2,
+		},
+		{
+			name:             "frames before the first keyframe are dropped",
+			keyframeInterval: 60,
+			leading:          10,
+			frames:           10 + 60 + 1,
+			durations:        []time.Duration{2 * time.Second},
+			keyframeRequests: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests := 0
+			packager := newHLSPackager(func() {
+				requests++
+			})
+
+			if _, ok := packager.Playlist(); ok {
+				t.Fatalf("playlist ready before the first segment")
+			}
+
+			seq := uint16(65500)
+			ts := uint32(0xffffff00)
+			// The sample builder emits a frame once the next one starts
+			for i := 0; i <= test.frames; i++ {
+				keyframe := i >= test.leading && (i-test.leading)%test.keyframeInterval == 0
+				for _, packet := range h264Frame(&seq, ts, keyframe) {
+					if err := packager.Write(packet); err != nil {
+						t.Fatalf("Write error: %v", err)
+					}
+				}
+				ts += testFrameTicks
+			}
+
+			if len(packager.segments) != len(test.durations) {
+				t.Fatalf("got %d segments, want %d", len(packager.segments), len(test.durations))
+			}
+
+			for i, segment := range packager.segments {
+				if segment.Seq != i || segment.Duration != test.durations[i] {
+					t.Fatalf("got segment %d of %s, want %d of %s", segment.Seq, segment.Duration, i, test.durations[i])
+				}
+
+				// Every segment starts with the tables and a keyframe
+				data, ok := packager.Segment(segment.Seq)
+				if !ok || len(data)%tsPacketSize != 0 {
+					t.Fatalf("segment %d has %d bytes", segment.Seq, len(data))
+				}
+
+				tables := &bytes.Buffer{}
+				newTSMuxer().WriteTables(tables)
+				pat, pmt := tables.Bytes()[4:tsPacketSize], tables.Bytes()[tsPacketSize+4:]
+				if !bytes.Equal(data[4:tsPacketSize], pat) || !bytes.Equal(data[tsPacketSize+4:2*tsPacketSize], pmt) {
+					t.Fatalf("segment %d does not start with the tables", segment.Seq)
+				}
+
+				video := data[2*tsPacketSize:]
+				if video[1]&0x40 == 0 || video[5]&0x40 == 0 {
+					t.Fatalf("segment %d does not start with a keyframe", segment.Seq)
+				}
+			}
+
+			if requests != test.keyframeRequests {
+				t.Fatalf("got %d keyframe requests, want %d", requests, test.keyframeRequests)
+			}
+
+			playlist, ok := packager.Playlist()
+			if !ok {
+				t.Fatalf("playlist not ready")
+			}
+
+			if !strings.Contains(playlist, "#EXT-X-MEDIA-SEQUENCE:0\n") || !strings.Contains(playlist, fmt.Sprintf("%d%s\n", len(test.durations)-1, hlsSegmentExt)) {
+				t.Fatalf("unexpected playlist\n%s", playlist)
+			}
+		})
+	}
+}
+
+func TestHLSPackagerPlaylistWindow(t *testing.T) {
+	packager := newHLSPackager(nil)
+
+	seq := uint16(0)
+	ts := uint32(0)
+	segments := hlsRetainedSegments + 2
+	// The sample builder emits the keyframe closing the last segment once the next frame starts
+	for i := 0; i <= segments*60+1; i++ {
+		for _, packet := range h264Frame(&seq, ts, i%60 == 0) {
+			if err := packager.Write(packet); err != nil {
+				t.Fatalf("Write error: %v", err)
+			}
+		}
+		ts += testFrameTicks
+	}
+
+	playlist, ok := packager.Playlist()
+	if !ok {
+		t.Fatalf("playlist not ready")
+	}
+
+	want := &strings.Builder{}
+	want.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:2\n")
+	fmt.Fprintf(want, "#EXT-X-MEDIA-SEQUENCE:%d\n", segments-hlsPlaylistSize)
+	for i := segments - hlsPlaylistSize; i < segments; i++ {
+		fmt.Fprintf(want, "#EXTINF:2.000,\n%d.ts\n", i)
+	}
+
+	if playlist != want.String() {
+		t.Fatalf("got playlist\n%s\nwant\n%s", playlist, want.String())
+	}
+
+	// Segments dropped from the playlist are retained a little longer
+	for i := 0; i < segments; i++ {
+		_, ok := packager.Segment(i)
+		if retained := i >= segments-hlsRetainedSegments; ok != retained {
+			t.Fatalf("segment %d retained %v, want %v", i, ok, retained)
+		}
+	}
+}
+
+// pcrs returns the PCRs and the PES header flags of the video packets of a segment
+func pcrs(segment []byte) ([]int64, []byte) {
+	pcrs, flags := []int64{}, []byte{}
+	for i := 0; i+tsPacketSize <= len(segment); i += tsPacketSize {
+		packet := segment[i : i+tsPacketSize]
+		if uint16(packet[1]&0x1f)<<8|uint16(packet[2]) != tsVideoPID || packet[1]&0x40 == 0 {
+			continue
+		}
+
+		af := packet[4 : 5+int(packet[4])]
+		pcr := packet[6:12]
+		pcrs = append(pcrs, int64(pcr[0])<<25|int64(pcr[1])<<17|int64(pcr[2])<<9|int64(pcr[3])<<1|int64(pcr[4])>>7)
+		flags = append(flags, packet[4+len(af)+7])
+	}
+
+	return pcrs, flags
+}
+
+func TestHLSPackagerBFrames(t *testing.T) {
+	packager := newHLSPackager(nil)
+
+	// Decode order I P B B P B B... like OBS with 2 B-frames so the presentation times go back
+	frames := 2*60 + 1
+	for i := 0; i < frames; i++ {
+		// Presentation order in the group of pictures
+		j := i % 60
+		presented := j
+		switch {
+		case j == 0 || j >= 58:
+		case (j-1)%3 == 0:
+			presented = j + 2
+		default:
+			presented = j - 1
+		}
+
+		data := []byte{0x00, 0x00, 0x00, 0x01, 0x41, 0x9a}
+		if j == 0 {
+			data = []byte{0x00, 0x00, 0x00, 0x01, 0x65, 0x88}
+		}
+
+		// The presentation is delayed by 2 frames so it never precedes the decoding
+		dts := uint32(0xffff0000) + uint32(i*testFrameTicks)
+		pts := dts + uint32((presented-j+2)*testFrameTicks)
+		packager.WriteAccessUnit(data, pts, dts)
+	}
+
+	if len(packager.segments) != 2 {
+		t.Fatalf("got %d segments, want 2", len(packager.segments))
+	}
+
+	last := int64(-1)
+	for _, segment := range packager.segments {
+		if segment.Duration != 2*time.Second {
+			t.Fatalf("got segment %d of %s, want 2s", segment.Seq, segment.Duration)
+		}
+
+		pcrs, flags := pcrs(segment.Data)
+		if len(pcrs) != 60 {
+			t.Fatalf("segment %d has %d frames, want 60", segment.Seq, len(pcrs))
+		}
+
+		for i, pcr := range pcrs {
+			if pcr <= last {
+				t.Fatalf("segment %d frame %d PCR %d does not increase from %d", segment.Seq, i, pcr, last)
+			}
+			last = pcr
+
+			// Frames presented later than decoded carry both timestamps
+			if flags[i] != 0xc0 {
+				t.Fatalf("segment %d frame %d has PES flags %x, want PTS and DTS", segment.Seq, i, flags[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package broadcast
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	// Minimum time between two keyframe requests sent to a broadcaster
+	keyframeRequestInterval = 500 * time.Millisecond
+)
+
+// keyframeForwarder aggregates keyframe requests (PLI/FIR) from participants and forwards
+// them to the broadcaster as PLIs. Requests arriving within the interval of the last forwarded
+// request are coalesced into one delayed request so the broadcaster is not flooded.
+type keyframeForwarder struct {
+	canxCtx        context.Context
+	errorStream    chan error
+	peerConnection *webrtc.PeerConnection
+	mediaSSRC      uint32
+	metrics        *mediaMetrics
+
+	mutex   sync.Mutex
+	last    time.Time
+	pending bool
+}
+
+func newKeyframeForwarder(canxCtx context.Context,
+	errorStream chan error,
+	peerConnection *webrtc.PeerConnection,
+	remoteTrack *webrtc.TrackRemote,
+	metrics *mediaMetrics) *keyframeForwarder {
+	return &keyframeForwarder{
+		canxCtx:        canxCtx,
+		errorStream:    errorStream,
+		peerConnection: peerConnection,
+		mediaSSRC:      uint32(remoteTrack.SSRC()),
+		metrics:        metrics,
+	}
+}
+
+// Request asks the broadcaster for a keyframe subject to rate limiting
+func (k *keyframeForwarder) Request() {
+	k.metrics.KeyframeRequested(k.canxCtx)
+
+	k.mutex.Lock()
+	if k.pending {
+		// Already scheduled, coalesce
+		k.mutex.Unlock()
+		return
+	}
+
+	wait := keyframeRequestInterval - time.Since(k.last)
+	if wait > 0 {
+		k.pending = true
+		k.mutex.Unlock()
+
+		time.AfterFunc(wait, func() {
+			k.mutex.Lock()
+			k.pending = false
+			k.last = time.Now()
+			k.mutex.Unlock()
+
+			k.send()
+		})
+		return
+	}
+
+	k.last = time.Now()
+	k.mutex.Unlock()
+
+	k.send()
+}
+
+// send writes a PLI to the broadcaster
+func (k *keyframeForwarder) send() {
+	if k.canxCtx.Err() != nil {
+		return
+	}
+
+	err := k.peerConnection.WriteRTCP([]rtcp.Packet{
+		&rtcp.PictureLossIndication{
+			MediaSSRC: k.mediaSSRC,
+		},
+	})
+	if err != nil && k.canxCtx.Err() == nil {
+		k.errorStream <- fmt.Errorf("keyframeForwarder WriteRTCP error: %v", err)
+	}
+}
+
+// isKeyframeRequest reports whether any of the RTCP packets asks for a keyframe
+func isKeyframeRequest(packets []rtcp.Packet) bool {
+	for _, packet := range packets {
+		switch packet.(type) {
+		case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+			return true
+		}
+	}
+
+	return false
+}
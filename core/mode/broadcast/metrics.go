@@ -0,0 +1,230 @@
+package broadcast
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/khaledhikmat/family-meeting/service/lgr"
+	"github.com/khaledhikmat/family-meeting/service/signaling"
+)
+
+const (
+	rtpIn  = "in"
+	rtpOut = "out"
+)
+
+var (
+	activeBroadcasts    metric.Int64UpDownCounter
+	activeParticipants  metric.Int64UpDownCounter
+	rtpPackets          metric.Int64Counter
+	rtpBytes            metric.Int64Counter
+	rtpDropped          metric.Int64Counter
+	keyframeRequests    metric.Int64Counter
+	negotiationDuration metric.Int64Histogram
+	firstPacketDuration metric.Int64Histogram
+)
+
+func init() {
+	var err error
+	activeBroadcasts, err = meter.Int64UpDownCounter(
+		"family.meeting.broadcast.active",
+		metric.WithDescription("The number of live broadcasts"),
+		metric.WithUnit("1"),
+	)
+	logInstrumentError("creating up down counter", err)
+
+	activeParticipants, err = meter.Int64UpDownCounter(
+		"family.meeting.broadcast.participants.active",
+		metric.WithDescription("The number of participants attached to live broadcasts"),
+		metric.WithUnit("1"),
+	)
+	logInstrumentError("creating up down counter", err)
+
+	rtpPackets, err = meter.Int64Counter(
+		"family.meeting.broadcast.rtp.packets",
+		metric.WithDescription("The number of RTP packets received from broadcasters (in) and sent to participants (out)"),
+		metric.WithUnit("1"),
+	)
+	logInstrumentError("creating counter", err)
+
+	rtpBytes, err = meter.Int64Counter(
+		"family.meeting.broadcast.rtp.bytes",
+		metric.WithDescription("The number of RTP bytes received from broadcasters (in) and sent to participants (out)"),
+		metric.WithUnit("By"),
+	)
+	logInstrumentError("creating counter", err)
+
+	rtpDropped, err = meter.Int64Counter(
+		"family.meeting.broadcast.rtp.dropped",
+		metric.WithDescription("The number of broadcaster RTP packets that could not be forwarded to participants"),
+		metric.WithUnit("1"),
+	)
+	logInstrumentError("creating counter", err)
+
+	keyframeRequests, err = meter.Int64Counter(
+		"family.meeting.broadcast.keyframe.requests",
+		metric.WithDescription("The number of keyframe requests for the broadcaster video"),
+		metric.WithUnit("1"),
+	)
+	logInstrumentError("creating counter", err)
+
+	negotiationDuration, err = meter.Int64Histogram(
+		"family.meeting.broadcast.negotiation.duration",
+		metric.WithDescription("The distribution of durations from the offer to a connected broadcaster or participant"),
+		metric.WithUnit("ms"),
+	)
+	logInstrumentError("creating histogram", err)
+
+	firstPacketDuration, err = meter.Int64Histogram(
+		"family.meeting.broadcast.first_packet.duration",
+		metric.WithDescription("The distribution of durations from the offer to the first broadcaster packet of a track"),
+		metric.WithUnit("ms"),
+	)
+	logInstrumentError("creating histogram", err)
+}
+
+func logInstrumentError(msg string, err error) {
+	if err != nil {
+		lgr.Logger.Error(
+			msg,
+			slog.Any("error", xerrors.New(err.Error())),
+		)
+	}
+}
+
+// metricAttributes tags the measurements with the broadcast and this instance
+func metricAttributes(broadcastID string, kvs ...attribute.KeyValue) metric.MeasurementOption {
+	return metric.WithAttributes(append([]attribute.KeyValue{
+		attribute.String("broadcast", broadcastID),
+		attribute.String("instance", signaling.InstanceID()),
+	}, kvs...)...)
+}
+
+// mediaMetrics records the media metrics of a broadcast from the start of its negotiation.
+// A WHIP broadcast only gets its ID once the answer is ready so the ID is bound later. It is
+// always bound before the answer is returned i.e. before any media flows.
+type mediaMetrics struct {
+	start       time.Time
+	mutex       sync.Mutex
+	broadcastID string
+}
+
+func newMediaMetrics(broadcastID string) *mediaMetrics {
+	return &mediaMetrics{
+		start:       time.Now(),
+		broadcastID: broadcastID,
+	}
+}
+
+func (m *mediaMetrics) bind(broadcastID string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.broadcastID = broadcastID
+}
+
+func (m *mediaMetrics) attributes(kvs ...attribute.KeyValue) metric.MeasurementOption {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return metricAttributes(m.broadcastID, kvs...)
+}
+
+// Negotiated records the negotiation duration of the broadcaster
+func (m *mediaMetrics) Negotiated(ctx context.Context) {
+	negotiationDuration.Record(ctx, time.Since(m.start).Milliseconds(),
+		m.attributes(attribute.String("role", broadcasterPeer)))
+}
+
+// ParticipantNegotiated records the negotiation duration of a participant that started at start
+func (m *mediaMetrics) ParticipantNegotiated(ctx context.Context, start time.Time) {
+	negotiationDuration.Record(ctx, time.Since(start).Milliseconds(),
+		m.attributes(attribute.String("role", "participant")))
+}
+
+// Dropped counts a broadcaster packet that could not be forwarded to the participants
+func (m *mediaMetrics) Dropped(ctx context.Context, kind string) {
+	rtpDropped.Add(ctx, 1, m.attributes(attribute.String("kind", kind)))
+}
+
+// KeyframeRequested counts a keyframe request for the broadcaster video
+func (m *mediaMetrics) KeyframeRequested(ctx context.Context) {
+	keyframeRequests.Add(ctx, 1, m.attributes())
+}
+
+// Counter returns the RTP counter of a track in the direction
+func (m *mediaMetrics) Counter(kind string, direction string) *rtpCounter {
+	return &rtpCounter{
+		metrics:   m,
+		kind:      kind,
+		direction: direction,
+	}
+}
+
+// rtpCounter counts the RTP packets and bytes of a track in one direction. The first packet
+// received from the broadcaster also records the time to first packet. The attributes are
+// resolved on the first packet so they are not rebuilt for every packet.
+type rtpCounter struct {
+	metrics   *mediaMetrics
+	kind      string
+	direction string
+	once      sync.Once
+	options   metric.AddOption
+}
+
+// Add counts a packet of n bytes
+func (c *rtpCounter) Add(ctx context.Context, n int) {
+	c.once.Do(func() {
+		c.options = c.metrics.attributes(
+			attribute.String("kind", c.kind),
+			attribute.String("direction", c.direction),
+		)
+
+		if c.direction == rtpIn {
+			firstPacketDuration.Record(ctx, time.Since(c.metrics.start).Milliseconds(),
+				c.metrics.attributes(attribute.String("kind", c.kind)))
+		}
+	})
+
+	rtpPackets.Add(ctx, 1, c.options)
+	rtpBytes.Add(ctx, int64(n), c.options)
+}
+
+// rtpOutFactory creates the interceptors counting the RTP packets sent to a participant
+type rtpOutFactory struct {
+	metrics *mediaMetrics
+}
+
+func (f *rtpOutFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return &rtpOutInterceptor{
+		metrics: f.metrics,
+	}, nil
+}
+
+type rtpOutInterceptor struct {
+	interceptor.NoOp
+	metrics *mediaMetrics
+}
+
+// BindLocalStream counts the packets written to the participant track
+func (i *rtpOutInterceptor) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	kind, _, _ := strings.Cut(info.MimeType, "/")
+	counter := i.metrics.Counter(kind, rtpOut)
+
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		n, err := writer.Write(header, payload, attributes)
+		if err == nil {
+			counter.Add(context.Background(), n)
+		}
+		return n, err
+	})
+}
@@ -0,0 +1,197 @@
+package broadcast
+
+import (
+	"bytes"
+)
+
+/*
+Reference:
+ISO/IEC 13818-1 (MPEG-2 Transport Stream)
+Only what the HLS packager needs is implemented: one program with one H264 video stream.
+*/
+const (
+	tsPacketSize     = 188
+	tsPayloadSize    = tsPacketSize - 4
+	tsPATPID         = 0x0000
+	tsPMTPID         = 0x1000
+	tsVideoPID       = 0x0100
+	tsStreamTypeH264 = 0x1b
+	tsVideoStreamID  = 0xe0
+)
+
+// H264 access unit delimiter. Some players require it at the start of every access unit.
+var h264AUD = []byte{0x00, 0x00, 0x00, 0x01, 0x09, 0xf0}
+
+// tsMuxer writes H264 access units as MPEG-TS packets. The continuity counters are kept
+// across segments so consecutive segments form one continuous stream.
+type tsMuxer struct {
+	continuity map[uint16]byte
+}
+
+func newTSMuxer() *tsMuxer {
+	return &tsMuxer{
+		continuity: map[uint16]byte{},
+	}
+}
+
+// WriteTables writes the PAT and the PMT. Every segment starts with them so it can be decoded alone.
+func (m *tsMuxer) WriteTables(w *bytes.Buffer) {
+	pat := []byte{
+		0x00,       // table id
+		0xb0, 0x0d, // section syntax indicator and section length
+		0x00, 0x01, // transport stream id
+		0xc1,       // version and current/next indicator
+		0x00, 0x00, // section number and last section number
+		0x00, 0x01, // program number
+		0xe0 | byte(tsPMTPID>>8), byte(tsPMTPID & 0xff),
+	}
+	m.writeSection(w, tsPATPID, pat)
+
+	pmt := []byte{
+		0x02,       // table id
+		0xb0, 0x12, // section syntax indicator and section length
+		0x00, 0x01, // program number
+		0xc1,       // version and current/next indicator
+		0x00, 0x00, // section number and last section number
+		0xe0 | byte(tsVideoPID>>8), byte(tsVideoPID & 0xff), // PCR PID
+		0xf0, 0x00, // program info length
+		tsStreamTypeH264,
+		0xe0 | byte(tsVideoPID>>8), byte(tsVideoPID & 0xff),
+		0xf0, 0x00, // ES info length
+	}
+	m.writeSection(w, tsPMTPID, pmt)
+}
+
+// WriteVideo writes one Annex-B access unit as a PES packet. The DTS is only written when it differs
+// from the PTS i.e. when the stream has B-frames. The PCR is derived from the DTS, which always
+// increases, and is carried on the video PID.
+func (m *tsMuxer) WriteVideo(w *bytes.Buffer, au []byte, pts int64, dts int64, keyframe bool) {
+	pes := make([]byte, 0, 19+len(h264AUD)+len(au))
+	pes = append(pes,
+		0x00, 0x00, 0x01, tsVideoStreamID,
+		0x00, 0x00, // unbounded packet length is allowed for video
+		0x80, // marker bits
+	)
+	if pts == dts {
+		pes = append(pes,
+			0x80, // PTS only
+			0x05, // header data length
+		)
+		pes = append(pes, encodeTimestamp(0x02, pts)...)
+	} else {
+		pes = append(pes,
+			0xc0, // PTS and DTS
+			0x0a, // header data length
+		)
+		pes = append(pes, encodeTimestamp(0x03, pts)...)
+		pes = append(pes, encodeTimestamp(0x01, dts)...)
+	}
+	pes = append(pes, h264AUD...)
+	pes = append(pes, au...)
+
+	first := true
+	for len(pes) > 0 {
+		var af []byte
+		if first {
+			flags := byte(0x10) // PCR
+			if keyframe {
+				flags |= 0x40 // random access indicator
+			}
+			af = append([]byte{0x07, flags}, encodePCR(dts)...)
+		}
+
+		space := tsPayloadSize - len(af)
+		if len(pes) < space {
+			af = stuff(af, space-len(pes))
+			space = len(pes)
+		}
+
+		header := m.header(tsVideoPID, first, af != nil)
+		w.Write(header)
+		w.Write(af)
+		w.Write(pes[:space])
+
+		pes = pes[space:]
+		first = false
+	}
+}
+
+func (m *tsMuxer) writeSection(w *bytes.Buffer, pid uint16, section []byte) {
+	section = append(section, crc32MPEG2(section)...)
+
+	packet := bytes.Repeat([]byte{0xff}, tsPacketSize)
+	copy(packet, m.header(pid, true, false))
+	packet[4] = 0x00 // pointer field
+	copy(packet[5:], section)
+	w.Write(packet)
+}
+
+func (m *tsMuxer) header(pid uint16, start bool, adaptation bool) []byte {
+	cc := m.continuity[pid]
+	m.continuity[pid] = (cc + 1) & 0x0f
+
+	b1 := byte(pid>>8) & 0x1f
+	if start {
+		b1 |= 0x40
+	}
+
+	control := byte(0x10) // payload only
+	if adaptation {
+		control = 0x30 // adaptation field followed by payload
+	}
+
+	return []byte{0x47, b1, byte(pid & 0xff), control | cc}
+}
+
+// stuff pads the adaptation field (creating it if needed) with n bytes
+func stuff(af []byte, n int) []byte {
+	if af != nil {
+		af[0] += byte(n)
+		return append(af, bytes.Repeat([]byte{0xff}, n)...)
+	}
+
+	if n == 1 {
+		return []byte{0x00}
+	}
+
+	af = []byte{byte(n - 1), 0x00}
+	return append(af, bytes.Repeat([]byte{0xff}, n-2)...)
+}
+
+// encodeTimestamp encodes a PTS or a DTS. The prefix tells which one it is.
+func encodeTimestamp(prefix byte, ts int64) []byte {
+	return []byte{
+		prefix<<4 | byte((ts>>29)&0x0e) | 0x01,
+		byte(ts >> 22),
+		byte((ts>>14)&0xfe) | 0x01,
+		byte(ts >> 7),
+		byte((ts<<1)&0xfe) | 0x01,
+	}
+}
+
+func encodePCR(pcr int64) []byte {
+	return []byte{
+		byte(pcr >> 25),
+		byte(pcr >> 17),
+		byte(pcr >> 9),
+		byte(pcr >> 1),
+		byte((pcr&0x01)<<7) | 0x7e,
+		0x00,
+	}
+}
+
+func crc32MPEG2(data []byte) []byte {
+	crc := uint32(0xffffffff)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = (crc << 1) ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return []byte{byte(crc >> 24), byte(crc >> 16), byte(crc >> 8), byte(crc)}
+}
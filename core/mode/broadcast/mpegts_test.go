@@ -0,0 +1,200 @@
+package broadcast
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCRC32MPEG2(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		crc  []byte
+	}{
+		{
+			name: "check value",
+			data: []byte("123456789"),
+			crc:  []byte{0x03, 0x76, 0xe6, 0xe7},
+		},
+		{
+			name: "empty",
+			data: []byte{},
+			crc:  []byte{0xff, 0xff, 0xff, 0xff},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if crc := crc32MPEG2(test.data); !bytes.Equal(crc, test.crc) {
+				t.Fatalf("got %x, want %x", crc, test.crc)
+			}
+		})
+	}
+}
+
+// The tables are the ones ffmpeg writes for one H264 stream with the same PIDs
+func TestTSMuxerWriteTables(t *testing.T) {
+	pat := []byte{
+		0x47, 0x40, 0x00, 0x10, 0x00,
+		0x00, 0xb0, 0x0d, 0x00, 0x01, 0xc1, 0x00, 0x00, 0x00, 0x01, 0xf0, 0x00,
+		0x2a, 0xb1, 0x04, 0xb2,
+	}
+	pmt := []byte{
+		0x47, 0x50, 0x00, 0x10, 0x00,
+		0x02, 0xb0, 0x12, 0x00, 0x01, 0xc1, 0x00, 0x00, 0xe1, 0x00, 0xf0, 0x00, 0x1b, 0xe1, 0x00, 0xf0, 0x00,
+		0x15, 0xbd, 0x4d, 0x56,
+	}
+
+	muxer := newTSMuxer()
+	for segment := 0; segment < 2; segment++ {
+		w := &bytes.Buffer{}
+		muxer.WriteTables(w)
+
+		b := w.Bytes()
+		if len(b) != 2*tsPacketSize {
+			t.Fatalf("got %d bytes, want 2 packets", len(b))
+		}
+
+		for i, want := range [][]byte{pat, pmt} {
+			packet := b[i*tsPacketSize : (i+1)*tsPacketSize]
+
+			// The continuity counter is kept across segments
+			want = append([]byte(nil), want...)
+			want[3] |= byte(segment)
+
+			if !bytes.Equal(packet[:len(want)], want) {
+				t.Fatalf("segment %d packet %d got % x, want % x", segment, i, packet[:len(want)], want)
+			}
+
+			if !bytes.Equal(packet[len(want):], bytes.Repeat([]byte{0xff}, tsPacketSize-len(want))) {
+				t.Fatalf("segment %d packet %d is not stuffed", segment, i)
+			}
+		}
+	}
+}
+
+func TestTSMuxerWriteVideo(t *testing.T) {
+	pts := int64(0x1_2345_6789)
+
+	tests := []struct {
+		name     string
+		size     int
+		dts      int64
+		keyframe bool
+		packets  int
+		// PES header after the stream id and the packet length
+		header []byte
+	}{
+		{
+			name:     "keyframe in one packet",
+			size:     100,
+			dts:      pts,
+			keyframe: true,
+			packets:  1,
+			header:   []byte{0x80, 0x80, 0x05, 0x29, 0x8d, 0x15, 0xcf, 0x13}, // PTS 0x123456789
+		},
+		{
+			name:    "fills the first packet exactly",
+			size:    tsPayloadSize - 8 - 14 - len(h264AUD),
+			packets: 1,
+			dts:     pts,
+			header:  []byte{0x80, 0x80, 0x05, 0x29, 0x8d, 0x15, 0xcf, 0x13}, // PTS 0x123456789
+		},
+		{
+			name:    "one byte over the first packet",
+			size:    tsPayloadSize - 8 - 14 - len(h264AUD) + 1,
+			packets: 2,
+			dts:     pts,
+			header:  []byte{0x80, 0x80, 0x05, 0x29, 0x8d, 0x15, 0xcf, 0x13}, // PTS 0x123456789
+		},
+		{
+			name:    "last packet with a one byte adaptation field",
+			size:    tsPayloadSize - 8 - 14 - len(h264AUD) + tsPayloadSize - 1,
+			packets: 2,
+			dts:     pts,
+			header:  []byte{0x80, 0x80, 0x05, 0x29, 0x8d, 0x15, 0xcf, 0x13}, // PTS 0x123456789
+		},
+		{
+			name:    "b-frame",
+			size:    100,
+			dts:     pts - 3000,
+			packets: 1,
+			header: []byte{
+				0x80, 0xc0, 0x0a,
+				0x39, 0x8d, 0x15, 0xcf, 0x13, // PTS 0x123456789
+				0x19, 0x8d, 0x15, 0xb7, 0xa3, // DTS 0x123455bd1
+			},
+		},
+		{
+			name:    "b-frame fills the first packet exactly",
+			size:    tsPayloadSize - 8 - 19 - len(h264AUD),
+			dts:     pts - 3000,
+			packets: 1,
+			header: []byte{
+				0x80, 0xc0, 0x0a,
+				0x39, 0x8d, 0x15, 0xcf, 0x13, // PTS 0x123456789
+				0x19, 0x8d, 0x15, 0xb7, 0xa3, // DTS 0x123455bd1
+			},
+		},
+		{
+			name:    "many packets",
+			size:    5000,
+			packets: 28,
+			dts:     pts,
+			header:  []byte{0x80, 0x80, 0x05, 0x29, 0x8d, 0x15, 0xcf, 0x13}, // PTS 0x123456789
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			au := bytes.Repeat([]byte{0xab}, test.size)
+
+			w := &bytes.Buffer{}
+			newTSMuxer().WriteVideo(w, au, pts, test.dts, test.keyframe)
+
+			b := w.Bytes()
+			if len(b) != test.packets*tsPacketSize {
+				t.Fatalf("got %d bytes, want %d packets", len(b), test.packets)
+			}
+
+			pes := []byte{}
+			for i := 0; i < test.packets; i++ {
+				packet := b[i*tsPacketSize : (i+1)*tsPacketSize]
+
+				start := packet[1]&0x40 != 0
+				if packet[0] != 0x47 || start != (i == 0) || uint16(packet[1]&0x1f)<<8|uint16(packet[2]) != tsVideoPID {
+					t.Fatalf("packet %d has header % x", i, packet[:4])
+				}
+
+				if cc := int(packet[3] & 0x0f); cc != i&0x0f {
+					t.Fatalf("packet %d has continuity counter %d", i, cc)
+				}
+
+				payload := packet[4:]
+				if packet[3]&0x20 != 0 {
+					af := payload[:1+int(payload[0])]
+					if i == 0 {
+						// The PCR is the DTS which always increases
+						if af[1]&0x10 == 0 || !bytes.Equal(af[2:8], encodePCR(test.dts)) {
+							t.Fatalf("first packet has no PCR of the DTS % x", af)
+						}
+
+						if keyframe := af[1]&0x40 != 0; keyframe != test.keyframe {
+							t.Fatalf("got random access %v, want %v", keyframe, test.keyframe)
+						}
+					}
+					payload = payload[len(af):]
+				}
+				pes = append(pes, payload...)
+			}
+
+			header := []byte{0x00, 0x00, 0x01, tsVideoStreamID, 0x00, 0x00}
+			header = append(header, test.header...)
+			header = append(header, h264AUD...)
+
+			if !bytes.Equal(pes[:len(header)], header) || !bytes.Equal(pes[len(header):], au) {
+				t.Fatalf("got PES % x, want the header % x and the access unit", pes[:len(header)], header)
+			}
+		})
+	}
+}
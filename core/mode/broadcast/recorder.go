@@ -0,0 +1,303 @@
+package broadcast
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+	"github.com/pion/webrtc/v4/pkg/media/h264writer"
+	"github.com/pion/webrtc/v4/pkg/media/ivfwriter"
+	"github.com/pion/webrtc/v4/pkg/media/oggwriter"
+
+	"github.com/khaledhikmat/family-meeting/service/lgr"
+	"github.com/khaledhikmat/family-meeting/service/signaling"
+	"github.com/khaledhikmat/family-meeting/service/storage"
+	"github.com/khaledhikmat/family-meeting/utils"
+)
+
+const (
+	defaultRecordingsDir = "recordings"
+	recordingTimeFormat  = "20060102T150405Z"
+)
+
+// finalizeTimeout bounds the upload of a recording. It is long enough for a large file.
+const finalizeTimeout = 5 * time.Minute
+
+// trackRecorder writes the broadcaster RTP packets of one track to a file while recording is on.
+// VP8/VP9/AV1 are written to IVF, H264 to an Annex-B stream and Opus to Ogg.
+type trackRecorder struct {
+	codec webrtc.RTPCodecParameters
+	kind  webrtc.RTPCodecType
+
+	mutex   sync.Mutex
+	writer  media.Writer
+	path    string
+	started time.Time
+}
+
+// recordedFile is a finalized local recording of one track
+type recordedFile struct {
+	Path      string
+	Kind      webrtc.RTPCodecType
+	Codec     string
+	StartedAt time.Time
+	EndedAt   time.Time
+}
+
+func newTrackRecorder(codec webrtc.RTPCodecParameters, kind webrtc.RTPCodecType) *trackRecorder {
+	return &trackRecorder{
+		codec: codec,
+		kind:  kind,
+	}
+}
+
+// Start opens a new recording file in the directory. It is a no-op if already recording.
+func (r *trackRecorder) Start(dir string, name string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.writer != nil {
+		return nil
+	}
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	var writer media.Writer
+	path := filepath.Join(dir, name+"-"+r.kind.String())
+	switch strings.ToLower(r.codec.MimeType) {
+	case strings.ToLower(webrtc.MimeTypeVP8), strings.ToLower(webrtc.MimeTypeAV1):
+		path += ".ivf"
+		writer, err = ivfwriter.New(path, ivfwriter.WithCodec(r.codec.MimeType))
+	case strings.ToLower(webrtc.MimeTypeVP9):
+		path += ".ivf"
+		writer, err = newVP9Writer(path)
+	case strings.ToLower(webrtc.MimeTypeH264):
+		path += ".h264"
+		writer, err = h264writer.New(path)
+	case strings.ToLower(webrtc.MimeTypeOpus):
+		path += ".ogg"
+		writer, err = oggwriter.New(path, r.codec.ClockRate, r.codec.Channels)
+	default:
+		return fmt.Errorf("recording %s is not supported", r.codec.MimeType)
+	}
+	if err != nil {
+		return err
+	}
+
+	r.writer = writer
+	r.path = path
+	r.started = time.Now().UTC()
+	return nil
+}
+
+// Stop closes the recording file. The file path is empty if not recording.
+func (r *trackRecorder) Stop() (recordedFile, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.writer == nil {
+		return recordedFile{}, nil
+	}
+
+	err := r.writer.Close()
+	file := recordedFile{
+		Path:      r.path,
+		Kind:      r.kind,
+		Codec:     r.codec.MimeType,
+		StartedAt: r.started,
+		EndedAt:   time.Now().UTC(),
+	}
+	r.writer = nil
+	r.path = ""
+	return file, err
+}
+
+// Write records the RTP packet if recording is on
+func (r *trackRecorder) Write(buf []byte) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.writer == nil {
+		return nil
+	}
+
+	packet := &rtp.Packet{}
+	err := packet.Unmarshal(buf)
+	if err != nil {
+		return err
+	}
+
+	return r.writer.WriteRTP(packet)
+}
+
+// watchRecording starts and stops recording the broadcast tracks as the request `record` field
+// changes. Recording is finalized when the request is cancelled (i.e. aborted).
+func watchRecording(canxCtx context.Context,
+	requestCanxCtx context.Context,
+	errorStream chan error,
+	store signaling.SignalingStore,
+	sink storage.RecordingSink,
+	broadcastID string,
+	tracks []track) {
+	recording := false
+	defer func() {
+		if recording {
+			stopRecording(canxCtx, errorStream, store, sink, broadcastID, tracks)
+		}
+	}()
+
+	for request := range store.WatchRequest(canxCtx, requestCanxCtx, errorStream, broadcastID) {
+		if request.Record == recording {
+			continue
+		}
+
+		recording = request.Record
+		if recording {
+			startRecording(errorStream, broadcastID, tracks)
+			continue
+		}
+
+		stopRecording(canxCtx, errorStream, store, sink, broadcastID, tracks)
+	}
+}
+
+func startRecording(errorStream chan error,
+	broadcastID string,
+	tracks []track) {
+	dir := defaultRecordingsDir
+	if os.Getenv("RECORDINGS_DIR") != "" {
+		dir = os.Getenv("RECORDINGS_DIR")
+	}
+
+	dir = filepath.Join(dir, broadcastID)
+	name := time.Now().UTC().Format(recordingTimeFormat)
+	for _, t := range tracks {
+		err := t.Recorder.Start(dir, name)
+		if err != nil {
+			errorStream <- fmt.Errorf("startRecording %s track error: %v", t.Kind, err)
+			continue
+		}
+
+		// Video recordings are only decodable from a keyframe onwards
+		if t.Keyframe != nil {
+			t.Keyframe.Request()
+		}
+	}
+
+	lgr.Logger.Info("startRecording recording the broadcast",
+		slog.String("broadcast", broadcastID),
+		slog.String("dir", dir),
+	)
+}
+
+func stopRecording(canxCtx context.Context,
+	errorStream chan error,
+	store signaling.SignalingStore,
+	sink storage.RecordingSink,
+	broadcastID string,
+	tracks []track) {
+	for _, t := range tracks {
+		file, err := t.Recorder.Stop()
+		if err != nil {
+			errorStream <- fmt.Errorf("stopRecording %s track error: %v", t.Kind, err)
+			continue
+		}
+
+		if file.Path == "" {
+			continue
+		}
+
+		finalizeRecording(canxCtx, errorStream, store, sink, broadcastID, file)
+	}
+}
+
+// finalizeRecording saves the recorded file to the sink and links it to the broadcast request.
+// The local file is kept if it cannot be saved. It is finalized even if the context is cancelled
+// i.e. the broadcast stopped because the instance shuts down.
+func finalizeRecording(canxCtx context.Context,
+	errorStream chan error,
+	store signaling.SignalingStore,
+	sink storage.RecordingSink,
+	broadcastID string,
+	file recordedFile) {
+	info, err := os.Stat(file.Path)
+	if err != nil {
+		errorStream <- fmt.Errorf("finalizeRecording stat error: %v", err)
+		return
+	}
+
+	finalizeCtx, finalizeCanxFn := context.WithTimeout(context.WithoutCancel(canxCtx), finalizeTimeout)
+	defer finalizeCanxFn()
+
+	location, err := sink.Save(finalizeCtx, broadcastID+"/"+filepath.Base(file.Path), file.Path)
+	if err != nil {
+		errorStream <- fmt.Errorf("finalizeRecording sink.Save %s error: %v", file.Path, err)
+		return
+	}
+
+	recordingID, err := store.CreateRecording(finalizeCtx, utils.Recording{
+		BroadcastID: broadcastID,
+		Kind:        file.Kind.String(),
+		Codec:       file.Codec,
+		Location:    location,
+		Size:        info.Size(),
+		StartedAt:   file.StartedAt,
+		EndedAt:     file.EndedAt,
+	})
+	if err != nil {
+		errorStream <- fmt.Errorf("finalizeRecording store.CreateRecording %s error: %v", location, err)
+		return
+	}
+
+	lgr.Logger.Info("finalizeRecording saved the broadcast track recording",
+		slog.String("broadcast", broadcastID),
+		slog.String("recording", recordingID),
+		slog.String("location", location),
+	)
+}
+
+// recordingHandler starts or stops recording a broadcast by updating its request `record` field
+// so the instance running the broadcast picks it up
+func recordingHandler(store signaling.SignalingStore,
+	errorStream chan error,
+	record bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowCORS(c)
+
+		broadcastID := c.Param("id")
+		request, err := store.GetRequest(c, broadcastID)
+		if err != nil || (request.Kind != "broadcaster" && request.Kind != rtspKind && request.Kind != rtmpKind) {
+			c.String(http.StatusNotFound, "broadcast not found")
+			return
+		}
+
+		if request.Abort {
+			c.String(http.StatusConflict, "broadcast ended")
+			return
+		}
+
+		err = store.UpdateRequest(c, broadcastID, map[string]interface{}{
+			"record": record,
+		})
+		if err != nil {
+			errorStream <- fmt.Errorf("recordingHandler store.UpdateRequest error: %v", err)
+			c.String(http.StatusInternalServerError, "unable to update recording")
+			return
+		}
+
+		c.Status(http.StatusOK)
+	}
+}
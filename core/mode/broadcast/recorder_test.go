@@ -0,0 +1,72 @@
+package broadcast
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/khaledhikmat/family-meeting/service/signaling"
+)
+
+// contextSink fails like a real sink when the context is done
+type contextSink struct {
+	saved []string
+}
+
+func (s *contextSink) Save(canxCtx context.Context, key string, _ string) (string, error) {
+	if err := canxCtx.Err(); err != nil {
+		return "", err
+	}
+
+	if _, ok := canxCtx.Deadline(); !ok {
+		return "", context.DeadlineExceeded
+	}
+
+	s.saved = append(s.saved, key)
+	return "sink/" + key, nil
+}
+
+func (s *contextSink) Close() error {
+	return nil
+}
+
+func TestFinalizeRecordingAfterCancel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "video.ivf")
+	err := os.WriteFile(path, []byte("video"), 0o600)
+	if err != nil {
+		t.Fatalf("WriteFile error: %v", err)
+	}
+
+	store, err := signaling.NewMemory(context.Background())
+	if err != nil {
+		t.Fatalf("NewMemory error: %v", err)
+	}
+
+	// The broadcast stops because the instance shuts down
+	canxCtx, canxFn := context.WithCancel(context.Background())
+	canxFn()
+
+	sink := &contextSink{}
+	errorStream := make(chan error, 2)
+	finalizeRecording(canxCtx, errorStream, store, sink, "broadcast", recordedFile{
+		Path:      path,
+		Kind:      webrtc.RTPCodecTypeVideo,
+		Codec:     webrtc.MimeTypeVP8,
+		StartedAt: time.Now().Add(-time.Minute),
+		EndedAt:   time.Now(),
+	})
+
+	select {
+	case err := <-errorStream:
+		t.Fatalf("finalizeRecording error: %v", err)
+	default:
+	}
+
+	if len(sink.saved) != 1 || sink.saved[0] != "broadcast/video.ivf" {
+		t.Fatalf("got saved %v, want [broadcast/video.ivf]", sink.saved)
+	}
+}
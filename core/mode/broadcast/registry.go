@@ -0,0 +1,192 @@
+package broadcast
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Participant kinds i.e. how the participant joined
+const (
+	signalingParticipant = "participant"
+	whepParticipant      = "whep"
+)
+
+// broadcastSession is a live broadcast served by this instance
+type broadcastSession struct {
+	ID            string
+	RequestCtx    context.Context
+	RequestCanxFn context.CancelFunc
+	Tracks        []track
+	StartedAt     time.Time
+	Participants  map[string]*participantSession
+	// nil when the broadcaster has no peer connection i.e. RTSP and RTMP
+	Stats *peerStats
+}
+
+// participantSession is a participant attached to a live broadcast
+type participantSession struct {
+	ID       string
+	Kind     string
+	JoinedAt time.Time
+	CanxFn   context.CancelFunc
+	Stats    *peerStats
+}
+
+// registry keeps track of the live broadcasts and participants on this instance
+// so that the HTTP endpoints can reach them
+type registry struct {
+	mutex      sync.Mutex
+	broadcasts map[string]*broadcastSession
+}
+
+var sessions = &registry{
+	broadcasts: map[string]*broadcastSession{},
+}
+
+func (r *registry) addBroadcast(id string,
+	requestCanxCtx context.Context,
+	requestCanxFn context.CancelFunc,
+	localTracks []track,
+	stats *peerStats) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.broadcasts[id] = &broadcastSession{
+		ID:            id,
+		RequestCtx:    requestCanxCtx,
+		RequestCanxFn: requestCanxFn,
+		Tracks:        localTracks,
+		StartedAt:     time.Now(),
+		Participants:  map[string]*participantSession{},
+		Stats:         stats,
+	}
+}
+
+func (r *registry) removeBroadcast(id string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.broadcasts, id)
+}
+
+// getBroadcast returns a copy of the broadcast session without its participants
+func (r *registry) getBroadcast(id string) (broadcastSession, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	b, ok := r.broadcasts[id]
+	if !ok {
+		return broadcastSession{}, false
+	}
+
+	return broadcastSession{
+		ID:            b.ID,
+		RequestCtx:    b.RequestCtx,
+		RequestCanxFn: b.RequestCanxFn,
+		Tracks:        b.Tracks,
+		StartedAt:     b.StartedAt,
+		Stats:         b.Stats,
+	}, true
+}
+
+// listBroadcasts returns a copy of the broadcast sessions with their participants
+func (r *registry) listBroadcasts() []broadcastSession {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	broadcasts := []broadcastSession{}
+	for _, b := range r.broadcasts {
+		participants := map[string]*participantSession{}
+		for id, p := range b.Participants {
+			participant := *p
+			participants[id] = &participant
+		}
+
+		broadcasts = append(broadcasts, broadcastSession{
+			ID:            b.ID,
+			RequestCtx:    b.RequestCtx,
+			RequestCanxFn: b.RequestCanxFn,
+			Tracks:        b.Tracks,
+			StartedAt:     b.StartedAt,
+			Participants:  participants,
+			Stats:         b.Stats,
+		})
+	}
+
+	return broadcasts
+}
+
+func (r *registry) addParticipant(broadcastID string,
+	id string,
+	kind string,
+	canxFn context.CancelFunc,
+	stats *peerStats) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	b, ok := r.broadcasts[broadcastID]
+	if !ok {
+		return false
+	}
+
+	b.Participants[id] = &participantSession{
+		ID:       id,
+		Kind:     kind,
+		JoinedAt: time.Now(),
+		CanxFn:   canxFn,
+		Stats:    stats,
+	}
+	return true
+}
+
+func (r *registry) removeParticipant(broadcastID string, id string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	b, ok := r.broadcasts[broadcastID]
+	if !ok {
+		return
+	}
+
+	delete(b.Participants, id)
+}
+
+// cancelParticipant tears down a participant and reports whether it was found
+func (r *registry) cancelParticipant(broadcastID string, id string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	b, ok := r.broadcasts[broadcastID]
+	if !ok {
+		return false
+	}
+
+	p, ok := b.Participants[id]
+	if !ok {
+		return false
+	}
+
+	p.CanxFn()
+	return true
+}
+
+// ingressBitrate returns the bits per second received from the broadcaster
+func (r *registry) ingressBitrate(id string) int64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	b, ok := r.broadcasts[id]
+	if !ok {
+		return 0
+	}
+
+	var bitrate int64
+	for _, t := range b.Tracks {
+		if t.Ingress != nil {
+			bitrate += t.Ingress.Bitrate()
+		}
+	}
+
+	return bitrate
+}
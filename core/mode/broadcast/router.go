@@ -0,0 +1,193 @@
+package broadcast
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"github.com/khaledhikmat/family-meeting/server"
+	"github.com/khaledhikmat/family-meeting/service/lgr"
+	"github.com/khaledhikmat/family-meeting/service/signaling"
+	"github.com/khaledhikmat/family-meeting/service/storage"
+)
+
+// Router exposes the broadcast HTTP endpoints
+func Router(canxCtx context.Context,
+	store signaling.SignalingStore,
+	sink storage.RecordingSink,
+	errorStream chan error) []server.Route {
+	routes := []server.Route{
+		{
+			Method:  http.MethodPost,
+			Path:    "/whip",
+			Handler: whipAuth(whipHandler(canxCtx, store, sink, errorStream)),
+		},
+		{
+			Method:  http.MethodDelete,
+			Path:    "/whip/:id",
+			Handler: whipAuth(whipDeleteHandler(store, errorStream)),
+		},
+		{
+			Method:  http.MethodOptions,
+			Path:    "/whip",
+			Handler: optionsHandler(),
+		},
+		{
+			Method:  http.MethodOptions,
+			Path:    "/whip/:id",
+			Handler: optionsHandler(),
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    "/whep/:id",
+			Handler: whepHandler(canxCtx, errorStream),
+		},
+		{
+			Method:  http.MethodDelete,
+			Path:    "/whep/:id/:session",
+			Handler: whepDeleteHandler(),
+		},
+		{
+			Method:  http.MethodOptions,
+			Path:    "/whep/:id",
+			Handler: optionsHandler(),
+		},
+		{
+			Method:  http.MethodOptions,
+			Path:    "/whep/:id/:session",
+			Handler: optionsHandler(),
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    "/rtsp",
+			Handler: whipAuth(rtspHandler(store, errorStream)),
+		},
+		{
+			Method:  http.MethodDelete,
+			Path:    "/rtsp/:id",
+			Handler: whipAuth(sourceDeleteHandler(store, errorStream, rtspKind)),
+		},
+		{
+			Method:  http.MethodOptions,
+			Path:    "/rtsp",
+			Handler: optionsHandler(),
+		},
+		{
+			Method:  http.MethodOptions,
+			Path:    "/rtsp/:id",
+			Handler: optionsHandler(),
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    "/rtmp",
+			Handler: whipAuth(rtmpHandler(store, errorStream)),
+		},
+		{
+			Method:  http.MethodDelete,
+			Path:    "/rtmp/:id",
+			Handler: whipAuth(sourceDeleteHandler(store, errorStream, rtmpKind)),
+		},
+		{
+			Method:  http.MethodOptions,
+			Path:    "/rtmp",
+			Handler: optionsHandler(),
+		},
+		{
+			Method:  http.MethodOptions,
+			Path:    "/rtmp/:id",
+			Handler: optionsHandler(),
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/hls/:id/:file",
+			Handler: hlsHandler(),
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    "/broadcasts/:id/recording",
+			Handler: whipAuth(recordingHandler(store, errorStream, true)),
+		},
+		{
+			Method:  http.MethodDelete,
+			Path:    "/broadcasts/:id/recording",
+			Handler: whipAuth(recordingHandler(store, errorStream, false)),
+		},
+		{
+			Method:  http.MethodOptions,
+			Path:    "/broadcasts/:id/recording",
+			Handler: optionsHandler(),
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/broadcasts/:id/stats",
+			Handler: statsAuth(statsHandler()),
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/broadcasts/:id/stats/stream",
+			Handler: statsAuth(statsStreamHandler(canxCtx)),
+		},
+		{
+			Method:  http.MethodOptions,
+			Path:    "/broadcasts/:id/stats",
+			Handler: optionsHandler(),
+		},
+		{
+			Method:  http.MethodOptions,
+			Path:    "/broadcasts/:id/stats/stream",
+			Handler: optionsHandler(),
+		},
+	}
+
+	if os.Getenv(whipTokenKey) == "" && os.Getenv(adminTokenKey) == "" {
+		lgr.Logger.Info("Router WHIP, RTSP, RTMP and recording endpoints reject all requests. Set WHIP_TOKEN to accept them.")
+	}
+
+	if os.Getenv(statsTokenKey) == "" && os.Getenv(adminTokenKey) == "" {
+		lgr.Logger.Info("Router stats endpoints reject all requests. Set STATS_TOKEN to accept them.")
+	}
+
+	// The admin endpoints are only exposed when a token is configured
+	if os.Getenv(adminTokenKey) == "" {
+		lgr.Logger.Info("Router admin endpoints are disabled. Set ADMIN_TOKEN to enable them.")
+		return routes
+	}
+
+	return append(routes, []server.Route{
+		{
+			Method:  http.MethodGet,
+			Path:    "/admin/broadcasts",
+			Handler: adminAuth(adminBroadcastsHandler()),
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/admin/broadcasts/:id",
+			Handler: adminAuth(adminBroadcastHandler(store)),
+		},
+		{
+			Method:  http.MethodDelete,
+			Path:    "/admin/broadcasts/:id",
+			Handler: adminAuth(adminAbortHandler(store, errorStream)),
+		},
+		{
+			Method:  http.MethodDelete,
+			Path:    "/admin/broadcasts/:id/participants/:participant",
+			Handler: adminAuth(adminKickHandler(store, errorStream)),
+		},
+		{
+			Method:  http.MethodOptions,
+			Path:    "/admin/broadcasts",
+			Handler: optionsHandler(),
+		},
+		{
+			Method:  http.MethodOptions,
+			Path:    "/admin/broadcasts/:id",
+			Handler: optionsHandler(),
+		},
+		{
+			Method:  http.MethodOptions,
+			Path:    "/admin/broadcasts/:id/participants/:participant",
+			Handler: optionsHandler(),
+		},
+	}...)
+}
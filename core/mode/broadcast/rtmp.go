@@ -0,0 +1,256 @@
+package broadcast
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/khaledhikmat/family-meeting/service/lgr"
+	"github.com/khaledhikmat/family-meeting/service/rtmp"
+	"github.com/khaledhikmat/family-meeting/service/signaling"
+	"github.com/khaledhikmat/family-meeting/service/storage"
+	"github.com/khaledhikmat/family-meeting/utils"
+)
+
+const (
+	rtmpKind        = "rtmp"
+	rtmpRequestor   = "rtmp"
+	defaultRTMPPort = "1935"
+	rtmpApp         = "live"
+	// Any dynamic payload type works since the local track rewrites it
+	rtmpPayloadType = 96
+	// RTMP timestamps are in milliseconds
+	rtmpClockRatio = h264ClockRate / 1000
+)
+
+// serveRTMP accepts RTMP publishers (i.e. OBS) on RTMP_PORT until the context is cancelled.
+// The stream key is the ID of an RTMP broadcast request (see rtmpHandler).
+func serveRTMP(canxCtx context.Context,
+	errorStream chan error,
+	store signaling.SignalingStore,
+	sink storage.RecordingSink) {
+	port := defaultRTMPPort
+	if os.Getenv("RTMP_PORT") != "" {
+		port = os.Getenv("RTMP_PORT")
+	}
+
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		errorStream <- fmt.Errorf("serveRTMP listen error: %v", err)
+		return
+	}
+
+	go func() {
+		<-canxCtx.Done()
+		if cErr := listener.Close(); cErr != nil {
+			errorStream <- fmt.Errorf("serveRTMP cannot close listener: %v", cErr)
+		}
+	}()
+
+	lgr.Logger.Info("serveRTMP listening for publishers",
+		slog.String("port", port),
+	)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if canxCtx.Err() == nil {
+				errorStream <- fmt.Errorf("serveRTMP accept error: %v", err)
+			}
+			return
+		}
+
+		go startRTMPBroadcaster(canxCtx, errorStream, store, sink, rtmp.NewConn(conn))
+	}
+}
+
+// startRTMPBroadcaster receives the H264 video of an RTMP publisher and serves it to participants
+// the same way as a WebRTC broadcaster. The broadcast ends when the publisher stops.
+func startRTMPBroadcaster(canxCtx context.Context,
+	errorStream chan error,
+	store signaling.SignalingStore,
+	sink storage.RecordingSink,
+	conn *rtmp.Conn) {
+	requestCanxCtx, requestCanxFn := context.WithCancel(canxCtx)
+	defer requestCanxFn()
+
+	// Closing the connection also unblocks the publisher reader
+	go func() {
+		<-requestCanxCtx.Done()
+		_ = conn.Close()
+	}()
+
+	err := conn.Handshake()
+	if err != nil {
+		errorStream <- fmt.Errorf("startRTMPBroadcaster conn.Handshake error: %v", err)
+		return
+	}
+
+	broadcastID, err := conn.WaitForPublish()
+	if err != nil {
+		errorStream <- fmt.Errorf("startRTMPBroadcaster conn.WaitForPublish error: %v", err)
+		return
+	}
+
+	request, err := store.GetRequest(canxCtx, broadcastID)
+	if err != nil || request.Kind != rtmpKind || request.Abort {
+		_ = conn.RejectPublish("unknown stream key")
+		errorStream <- fmt.Errorf("startRTMPBroadcaster rejected stream key %s", broadcastID)
+		return
+	}
+
+	if _, ok := sessions.getBroadcast(broadcastID); ok {
+		_ = conn.RejectPublish("stream key already publishing")
+		errorStream <- fmt.Errorf("startRTMPBroadcaster stream key %s already publishing", broadcastID)
+		return
+	}
+
+	if !capacity.admitBroadcast() {
+		_ = conn.RejectPublish("instance is at capacity")
+		errorStream <- fmt.Errorf("startRTMPBroadcaster stream key %s rejected at capacity", broadcastID)
+		return
+	}
+	defer capacity.releaseBroadcast()
+
+	metrics := newMediaMetrics(broadcastID)
+	err = conn.AcceptPublish()
+	if err != nil {
+		errorStream <- fmt.Errorf("startRTMPBroadcaster conn.AcceptPublish error: %v", err)
+		return
+	}
+	metrics.Negotiated(canxCtx)
+
+	signaling.SetStatus(canxCtx, errorStream, store, broadcastID, utils.StatusNegotiating, "")
+
+	lgr.Logger.Info("startRTMPBroadcaster receiving the publisher stream",
+		slog.String("broadcast", broadcastID),
+	)
+
+	localTrackStream := make(chan track)
+	go onRTMPTrack(canxCtx, requestCanxCtx, requestCanxFn, errorStream, store, broadcastID, conn, metrics, localTrackStream)
+
+	runBroadcaster(canxCtx, requestCanxCtx, requestCanxFn, errorStream, store, sink, broadcastID, localTrackStream, 1, nil)
+}
+
+// onRTMPTrack reads the publisher video messages for the source. The audio is dropped.
+// The source is created from the first sequence header which tells the profile of the video.
+func onRTMPTrack(canxCtx context.Context,
+	requestCanxCtx context.Context,
+	requestCanxFn context.CancelFunc,
+	errorStream chan error,
+	store signaling.SignalingStore,
+	broadcastID string,
+	conn *rtmp.Conn,
+	metrics *mediaMetrics,
+	localTrackStream chan track) {
+	myCanxCtx, myCanxFn := context.WithCancel(canxCtx)
+	defer myCanxFn()
+
+	var source *h264Source
+	for {
+		video, err := conn.ReadVideo()
+		if err != nil {
+			if requestCanxCtx.Err() != nil || myCanxCtx.Err() != nil {
+				return
+			}
+
+			// The publisher stopped so the broadcast ends for everybody
+			if errors.Is(err, io.EOF) {
+				lgr.Logger.Info("onRTMPTrack publisher stopped",
+					slog.String("broadcast", broadcastID),
+				)
+			} else {
+				errorStream <- fmt.Errorf("onRTMPTrack conn.ReadVideo error: %v", err)
+			}
+			abortBroadcast(canxCtx, errorStream, store, broadcastID)
+			requestCanxFn()
+			return
+		}
+
+		// The sequence header carries the parameter sets once (or when they change)
+		if video.AU == nil && source == nil {
+			source, err = newH264Source(errorStream, rtmpPayloadType, video.SPS, metrics)
+			if err != nil {
+				errorStream <- fmt.Errorf("onRTMPTrack %v", err)
+				signaling.SetStatus(canxCtx, errorStream, store, broadcastID, utils.StatusFailed, "unsupported publisher video")
+				abortBroadcast(canxCtx, errorStream, store, broadcastID)
+				requestCanxFn()
+				return
+			}
+			source.SetParameterSets(video.SPS, video.PPS)
+
+			select {
+			case <-requestCanxCtx.Done():
+				return
+			case localTrackStream <- source.Track(myCanxCtx, myCanxFn):
+			}
+			continue
+		}
+
+		if video.AU == nil {
+			source.SetParameterSets(video.SPS, video.PPS)
+			continue
+		}
+
+		// Frames sent before the sequence header cannot be decoded
+		if source == nil {
+			continue
+		}
+
+		// RTP carries the presentation time which differs from the decoding time with B-frames
+		err = source.WriteAccessUnit(video.AU, video.PresentationTime()*rtmpClockRatio, video.Time*rtmpClockRatio)
+		if err != nil {
+			// The participants would not get any media so the broadcast fails for everybody
+			errorStream <- fmt.Errorf("onRTMPTrack source.WriteAccessUnit error: %v", err)
+			signaling.SetStatus(canxCtx, errorStream, store, broadcastID, utils.StatusFailed, "unable to forward the publisher video")
+			abortBroadcast(canxCtx, errorStream, store, broadcastID)
+			requestCanxFn()
+			return
+		}
+	}
+}
+
+// rtmpHandler creates an RTMP broadcast request. Its ID is the stream key to publish with
+// and the broadcast ID participants join with.
+func rtmpHandler(store signaling.SignalingStore,
+	errorStream chan error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowCORS(c)
+
+		broadcastID, err := store.CreateRequest(c, utils.Request{
+			Requestor:   rtmpRequestor,
+			Kind:        rtmpKind,
+			Status:      utils.StatusRequested,
+			Transitions: []utils.Transition{signaling.NewTransition(utils.StatusRequested, "")},
+		})
+		if err != nil {
+			errorStream <- fmt.Errorf("rtmpHandler store.CreateRequest error: %v", err)
+			c.String(http.StatusInternalServerError, "unable to create broadcast")
+			return
+		}
+
+		port := defaultRTMPPort
+		if os.Getenv("RTMP_PORT") != "" {
+			port = os.Getenv("RTMP_PORT")
+		}
+
+		host, _, err := net.SplitHostPort(c.Request.Host)
+		if err != nil {
+			host = c.Request.Host
+		}
+
+		c.Header("Location", "/rtmp/"+broadcastID)
+		c.JSON(http.StatusCreated, gin.H{
+			"id":        broadcastID,
+			"url":       "rtmp://" + net.JoinHostPort(host, port) + "/" + rtmpApp,
+			"streamKey": broadcastID,
+		})
+	}
+}
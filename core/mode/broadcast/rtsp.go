@@ -0,0 +1,459 @@
+package broadcast
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/khaledhikmat/family-meeting/service/lgr"
+	"github.com/khaledhikmat/family-meeting/service/rtsp"
+	"github.com/khaledhikmat/family-meeting/service/signaling"
+	"github.com/khaledhikmat/family-meeting/service/storage"
+	"github.com/khaledhikmat/family-meeting/utils"
+)
+
+const (
+	rtspKind            = "rtsp"
+	rtspRequestor       = "rtsp"
+	rtspAllowedHostsKey = "RTSP_ALLOWED_HOSTS"
+	rtspCredentialsKey  = "RTSP_CREDENTIALS"
+)
+
+// cameraCredentials keeps the credentials of the camera URLs posted to this instance by URL.
+// They are not stored with the request so they cannot be read from the store.
+var cameraCredentials sync.Map
+
+// startRTSPBroadcaster pulls the H264 video of an RTSP camera (i.e. an IP camera) and serves it
+// to participants the same way as a WebRTC broadcaster. There is no offer to answer: the request
+// carries the camera URL instead.
+func startRTSPBroadcaster(canxCtx context.Context,
+	errorStream chan error,
+	store signaling.SignalingStore,
+	sink storage.RecordingSink,
+	broadcastID string) {
+	if broadcastID == "" {
+		errorStream <- fmt.Errorf("startRTSPBroadcaster broadcastID is empty")
+		return
+	}
+
+	// The broadcast is traced until it ends. Its logs carry the trace.
+	canxCtx, span := tracer.Start(canxCtx, "startRTSPBroadcaster",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attribute.String("broadcast", broadcastID)),
+	)
+	defer span.End()
+
+	requestCanxCtx, requestCanxFn := context.WithCancel(canxCtx)
+	defer requestCanxFn()
+
+	request, err := store.GetRequest(canxCtx, broadcastID)
+	if err != nil {
+		errorStream <- fmt.Errorf("startRTSPBroadcaster store.GetRequest error: %v", err)
+		return
+	}
+
+	signaling.SetStatus(canxCtx, errorStream, store, broadcastID, utils.StatusNegotiating, "")
+	metrics := newMediaMetrics(broadcastID)
+
+	client, sdp, media, err := connectRTSP(requestCanxCtx, request.URL)
+	if err != nil {
+		failSpan(span, err)
+		errorStream <- fmt.Errorf("startRTSPBroadcaster %v", err)
+		signaling.SetStatus(canxCtx, errorStream, store, broadcastID, utils.StatusFailed, err.Error())
+		return
+	}
+	metrics.Negotiated(canxCtx)
+
+	// Closing the connection also unblocks the camera reader
+	go func() {
+		<-requestCanxCtx.Done()
+		if cErr := client.Close(); cErr != nil {
+			errorStream <- fmt.Errorf("startRTSPBroadcaster cannot close rtsp client: %v", cErr)
+		}
+	}()
+
+	go func() {
+		if kErr := client.Keepalive(requestCanxCtx); kErr != nil {
+			errorStream <- fmt.Errorf("startRTSPBroadcaster client.Keepalive error: %v", kErr)
+		}
+	}()
+
+	// The camera SDP is stored as the answer to mark the request as picked up
+	err = store.SetAnswer(canxCtx, broadcastID, utils.Encode(&webrtc.SessionDescription{
+		Type: webrtc.SDPTypeAnswer,
+		SDP:  sdp,
+	}))
+	if err != nil {
+		errorStream <- fmt.Errorf("startRTSPBroadcaster store.SetAnswer error: %v", err)
+		signaling.SetStatus(canxCtx, errorStream, store, broadcastID, utils.StatusFailed, "unable to answer")
+		return
+	}
+
+	lgr.Logger.InfoContext(canxCtx, "startRTSPBroadcaster playing the camera stream",
+		slog.String("broadcast", broadcastID),
+		slog.String("url", client.URL()),
+	)
+
+	localTrackStream := make(chan track)
+	go onRTSPTrack(canxCtx, requestCanxCtx, requestCanxFn, errorStream, store, broadcastID, client, media, metrics, localTrackStream)
+
+	runBroadcaster(canxCtx, requestCanxCtx, requestCanxFn, errorStream, store, sink, broadcastID, localTrackStream, 1, nil)
+}
+
+// connectRTSP connects to the camera and plays its H264 video. The client is closed on error.
+func connectRTSP(canxCtx context.Context, cameraURL string) (*rtsp.Client, string, rtsp.Media, error) {
+	// Requests may also be created directly in the store so the URL is checked again
+	err := checkCameraURL(cameraURL)
+	if err != nil {
+		return nil, "", rtsp.Media{}, err
+	}
+
+	client, err := rtsp.Dial(canxCtx, addCredentials(cameraURL))
+	if err != nil {
+		return nil, "", rtsp.Media{}, err
+	}
+
+	// Close the client if the camera cannot be played
+	played := false
+	defer func() {
+		if !played {
+			_ = client.Close()
+		}
+	}()
+
+	sdp, base, err := client.Describe()
+	if err != nil {
+		return nil, "", rtsp.Media{}, fmt.Errorf("client.Describe error: %v", err)
+	}
+
+	media, err := rtsp.FindH264(sdp, base)
+	if err != nil {
+		return nil, "", rtsp.Media{}, err
+	}
+
+	err = client.Setup(media.ControlURL)
+	if err != nil {
+		return nil, "", rtsp.Media{}, fmt.Errorf("client.Setup error: %v", err)
+	}
+
+	err = client.Play()
+	if err != nil {
+		return nil, "", rtsp.Media{}, fmt.Errorf("client.Play error: %v", err)
+	}
+
+	played = true
+	return client, sdp, media, nil
+}
+
+// onRTSPTrack reads the camera RTP packets and rebuilds the H264 access units for the source.
+// Cameras often announce the parameter sets in the SDP only.
+func onRTSPTrack(canxCtx context.Context,
+	requestCanxCtx context.Context,
+	requestCanxFn context.CancelFunc,
+	errorStream chan error,
+	store signaling.SignalingStore,
+	broadcastID string,
+	client *rtsp.Client,
+	media rtsp.Media,
+	metrics *mediaMetrics,
+	localTrackStream chan track) {
+	myCanxCtx, myCanxFn := context.WithCancel(canxCtx)
+	defer myCanxFn()
+
+	depacketizer := &codecs.H264Packet{}
+
+	au := []byte{}
+	var timestamp uint32
+
+	// The camera is gone or its video cannot be forwarded so the broadcast fails for everybody
+	fail := func(err error, reason string) {
+		errorStream <- fmt.Errorf("onRTSPTrack %v", err)
+		signaling.SetStatus(canxCtx, errorStream, store, broadcastID, utils.StatusFailed, reason)
+		abortBroadcast(canxCtx, errorStream, store, broadcastID)
+		requestCanxFn()
+	}
+
+	// The source is created once the SPS is known since it tells the profile of the video.
+	// It is announced in the SDP or sent in band. Access units sent before cannot be decoded.
+	var source *h264Source
+	startSource := func() error {
+		sps := media.SPS
+		if sps == nil {
+			sps = findSPS(au)
+		}
+
+		if sps == nil {
+			return nil
+		}
+
+		s, err := newH264Source(errorStream, media.PayloadType, sps, metrics)
+		if err != nil {
+			return err
+		}
+		s.SetParameterSets(media.SPS, media.PPS)
+
+		select {
+		case <-requestCanxCtx.Done():
+			return requestCanxCtx.Err()
+		case localTrackStream <- s.Track(myCanxCtx, myCanxFn):
+		}
+
+		source = s
+		return nil
+	}
+
+	writeAccessUnit := func() bool {
+		if source == nil {
+			err := startSource()
+			if err != nil {
+				if requestCanxCtx.Err() == nil {
+					fail(err, "unsupported camera video")
+				}
+				return false
+			}
+
+			if source == nil {
+				return true
+			}
+		}
+
+		err := source.WriteAccessUnit(au, timestamp, timestamp)
+		if err != nil {
+			fail(fmt.Errorf("source.WriteAccessUnit error: %v", err), "unable to forward the camera video")
+			return false
+		}
+
+		return true
+	}
+
+	for {
+		buf, err := client.ReadRTP()
+		if err != nil {
+			if requestCanxCtx.Err() != nil || myCanxCtx.Err() != nil {
+				return
+			}
+
+			reason := "camera disconnected"
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				reason = "camera stopped sending video"
+			}
+
+			fail(fmt.Errorf("client.ReadRTP error: %v", err), reason)
+			return
+		}
+
+		packet := &rtp.Packet{}
+		if err = packet.Unmarshal(buf); err != nil || packet.PayloadType != media.PayloadType {
+			continue
+		}
+
+		// Not every camera sets the marker bit on the last packet of an access unit
+		if len(au) > 0 && packet.Timestamp != timestamp {
+			if !writeAccessUnit() {
+				return
+			}
+			au = au[:0]
+		}
+		timestamp = packet.Timestamp
+
+		nals, err := depacketizer.Unmarshal(packet.Payload)
+		if err != nil {
+			errorStream <- fmt.Errorf("onRTSPTrack depacketizer.Unmarshal error: %v", err)
+			continue
+		}
+		au = append(au, nals...)
+
+		if packet.Marker && len(au) > 0 {
+			if !writeAccessUnit() {
+				return
+			}
+			au = au[:0]
+		}
+	}
+}
+
+// findSPS returns the SPS of the Annex-B access unit if any
+func findSPS(au []byte) []byte {
+	for _, nal := range splitAnnexB(au) {
+		if nal[0]&0x1f == h264NALTypeSPS {
+			return append([]byte(nil), nal...)
+		}
+	}
+
+	return nil
+}
+
+func abortBroadcast(canxCtx context.Context,
+	errorStream chan error,
+	store signaling.SignalingStore,
+	broadcastID string) {
+	err := store.UpdateRequest(canxCtx, broadcastID, map[string]interface{}{
+		"abort": true,
+	})
+	if err != nil {
+		errorStream <- fmt.Errorf("abortBroadcast store.UpdateRequest error: %v", err)
+	}
+}
+
+// checkCameraURL only lets the core connect to the cameras of RTSP_ALLOWED_HOSTS so a request
+// cannot make it reach any other (i.e. internal) address. The allowed hosts are comma-separated
+// host names, IP addresses or CIDR prefixes. No camera is allowed if it is not set.
+func checkCameraURL(cameraURL string) error {
+	u, err := url.Parse(cameraURL)
+	if err != nil || u.Scheme != "rtsp" || u.Hostname() == "" {
+		return fmt.Errorf("url must be an rtsp:// URL")
+	}
+
+	host := u.Hostname()
+	addr, addrErr := netip.ParseAddr(host)
+	for _, allowed := range strings.Split(os.Getenv(rtspAllowedHostsKey), ",") {
+		allowed = strings.TrimSpace(allowed)
+		if allowed == "" {
+			continue
+		}
+
+		if strings.EqualFold(allowed, host) {
+			return nil
+		}
+
+		if addrErr != nil {
+			continue
+		}
+
+		if prefix, err := netip.ParsePrefix(allowed); err == nil && prefix.Contains(addr.Unmap()) {
+			return nil
+		}
+
+		if allowedAddr, err := netip.ParseAddr(allowed); err == nil && allowedAddr.Unmap() == addr.Unmap() {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("rtsp host %s is not allowed", host)
+}
+
+// keepCredentials returns the camera URL without its credentials. They are kept by this instance.
+func keepCredentials(cameraURL string) string {
+	u, err := url.Parse(cameraURL)
+	if err != nil || u.User == nil {
+		return cameraURL
+	}
+
+	userinfo := u.User
+	u.User = nil
+	cameraCredentials.Store(u.String(), userinfo)
+	return u.String()
+}
+
+// addCredentials returns the camera URL with the credentials kept by this instance or, if it
+// was posted to another instance, those of the camera host in RTSP_CREDENTIALS. They are
+// comma-separated `host=user:password` entries.
+func addCredentials(cameraURL string) string {
+	u, err := url.Parse(cameraURL)
+	if err != nil || u.User != nil {
+		return cameraURL
+	}
+
+	if userinfo, ok := cameraCredentials.Load(u.String()); ok {
+		u.User = userinfo.(*url.Userinfo)
+		return u.String()
+	}
+
+	for _, entry := range strings.Split(os.Getenv(rtspCredentialsKey), ",") {
+		host, credentials, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || !strings.EqualFold(host, u.Hostname()) {
+			continue
+		}
+
+		username, password, _ := strings.Cut(credentials, ":")
+		u.User = url.UserPassword(username, password)
+		return u.String()
+	}
+
+	return cameraURL
+}
+
+type rtspRequest struct {
+	URL string `json:"url"`
+}
+
+// rtspHandler creates an RTSP broadcast request for the camera URL. The monitor dispatches it
+// like any other broadcast and participants join using the returned broadcast ID.
+func rtspHandler(store signaling.SignalingStore,
+	errorStream chan error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowCORS(c)
+
+		body := rtspRequest{}
+		err := c.ShouldBindJSON(&body)
+		if err != nil {
+			c.String(http.StatusBadRequest, "invalid request: %v", err)
+			return
+		}
+
+		err = checkCameraURL(body.URL)
+		if err != nil {
+			c.String(http.StatusBadRequest, "%v", err)
+			return
+		}
+
+		broadcastID, err := store.CreateRequest(c, utils.Request{
+			Requestor:   rtspRequestor,
+			Kind:        rtspKind,
+			URL:         keepCredentials(body.URL),
+			Status:      utils.StatusRequested,
+			Transitions: []utils.Transition{signaling.NewTransition(utils.StatusRequested, "")},
+		})
+		if err != nil {
+			errorStream <- fmt.Errorf("rtspHandler store.CreateRequest error: %v", err)
+			c.String(http.StatusInternalServerError, "unable to create broadcast")
+			return
+		}
+
+		c.Header("Location", "/rtsp/"+broadcastID)
+		c.JSON(http.StatusCreated, gin.H{
+			"id": broadcastID,
+		})
+	}
+}
+
+// sourceDeleteHandler ends an RTSP or RTMP broadcast by aborting its request
+func sourceDeleteHandler(store signaling.SignalingStore,
+	errorStream chan error,
+	kind string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowCORS(c)
+
+		broadcastID := c.Param("id")
+		request, err := store.GetRequest(c, broadcastID)
+		if err != nil || request.Kind != kind {
+			c.String(http.StatusNotFound, "broadcast not found")
+			return
+		}
+
+		err = store.UpdateRequest(c, broadcastID, map[string]interface{}{
+			"abort": true,
+		})
+		if err != nil {
+			errorStream <- fmt.Errorf("sourceDeleteHandler store.UpdateRequest error: %v", err)
+			c.String(http.StatusInternalServerError, "unable to abort broadcast")
+			return
+		}
+
+		c.Status(http.StatusOK)
+	}
+}
@@ -0,0 +1,147 @@
+package broadcast
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	// Source video is re-packetized to fit the WebRTC path MTU
+	sourceMTU = 1200
+
+	h264ProfileBaseline = 0x42
+	h264ConstraintSet1  = 0x40
+	// constraint_set0_flag and constraint_set1_flag as browsers announce it i.e. 42e01f
+	h264ConstrainedBaseline = 0xe0
+)
+
+// sourceVideoCapability returns the capability the source video is offered to participants with.
+// The video is passed through so its profile and level are taken from the SPS. Only the
+// constrained baseline constraint is kept since participants match the profile and its
+// constraints exactly and the other constraints only narrow the profile down.
+func sourceVideoCapability(sps []byte) (webrtc.RTPCodecCapability, error) {
+	// NAL header, profile_idc, constraint flags and level_idc
+	if len(sps) < 4 || sps[0]&0x1f != h264NALTypeSPS {
+		return webrtc.RTPCodecCapability{}, fmt.Errorf("invalid h264 sps")
+	}
+
+	profile, constraints, level := sps[1], byte(0x00), sps[3]
+	if profile == h264ProfileBaseline && sps[2]&h264ConstraintSet1 != 0 {
+		constraints = h264ConstrainedBaseline
+	}
+
+	return webrtc.RTPCodecCapability{
+		MimeType:    webrtc.MimeTypeH264,
+		ClockRate:   h264ClockRate,
+		SDPFmtpLine: fmt.Sprintf("level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=%02x%02x%02x", profile, constraints, level),
+	}, nil
+}
+
+// h264Source feeds the H264 access units of a non-WebRTC broadcaster (i.e. an RTSP camera or
+// an RTMP publisher) to the local track participants attach to, the recorder and the HLS packager.
+// Keyframes are prefixed with the parameter sets since participants may join at any time and
+// these sources often send them once or out of band.
+type h264Source struct {
+	errorStream chan error
+	localTrack  *webrtc.TrackLocalStaticRTP
+	recorder    *trackRecorder
+	hls         *hlsPackager
+	params      h264Params
+	packetizer  rtp.Packetizer
+	ingress     *bitrateMeter
+	metrics     *mediaMetrics
+	received    *rtpCounter
+}
+
+// newH264Source creates the source of the video described by the SPS. The SPS is also used as
+// the initial parameter set.
+func newH264Source(errorStream chan error, payloadType uint8, sps []byte, metrics *mediaMetrics) (*h264Source, error) {
+	capability, err := sourceVideoCapability(sps)
+	if err != nil {
+		return nil, err
+	}
+
+	localTrack, err := webrtc.NewTrackLocalStaticRTP(capability, webrtc.RTPCodecTypeVideo.String(), localStreamID)
+	if err != nil {
+		return nil, fmt.Errorf("NewTrackLocalStaticRTP error: %v", err)
+	}
+
+	// These sources send keyframes at their own interval so nobody can request them
+	return &h264Source{
+		errorStream: errorStream,
+		localTrack:  localTrack,
+		recorder: newTrackRecorder(webrtc.RTPCodecParameters{
+			RTPCodecCapability: capability,
+			PayloadType:        webrtc.PayloadType(payloadType),
+		}, webrtc.RTPCodecTypeVideo),
+		hls:      newHLSPackager(nil),
+		ingress:  newBitrateMeter(),
+		metrics:  metrics,
+		received: metrics.Counter(webrtc.RTPCodecTypeVideo.String(), rtpIn),
+		params: h264Params{
+			sps: sps,
+		},
+		packetizer: rtp.NewPacketizer(sourceMTU, payloadType, 0, &codecs.H264Payloader{}, rtp.NewRandomSequencer(), h264ClockRate),
+	}, nil
+}
+
+// Track returns the track to stream to the local track stream
+func (s *h264Source) Track(canxCtx context.Context, canxFn context.CancelFunc) track {
+	return track{
+		Ctx:      canxCtx,
+		CtxFn:    canxFn,
+		Kind:     webrtc.RTPCodecTypeVideo,
+		Track:    s.localTrack,
+		Recorder: s.recorder,
+		HLS:      s.hls,
+		Ingress:  s.ingress,
+		Metrics:  s.metrics,
+	}
+}
+
+// SetParameterSets sets the parameter sets announced out of band
+func (s *h264Source) SetParameterSets(sps []byte, pps []byte) {
+	if sps != nil {
+		s.params.sps = sps
+	}
+
+	if pps != nil {
+		s.params.pps = pps
+	}
+}
+
+// WriteAccessUnit packetizes the Annex-B access unit. The RTP timestamp is the presentation time.
+// The decoding time differs when the source has B-frames. Both are in the 90kHz clock.
+func (s *h264Source) WriteAccessUnit(data []byte, pts uint32, dts uint32) error {
+	au, _ := s.params.accessUnit(data)
+	for _, packet := range s.packetizer.Packetize(au, 0) {
+		// Keep the source timing
+		packet.Timestamp = pts
+
+		buf, err := packet.Marshal()
+		if err != nil {
+			return err
+		}
+		s.ingress.Add(len(buf))
+		s.received.Add(context.Background(), len(buf))
+
+		if _, err = s.localTrack.Write(buf); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			s.metrics.Dropped(context.Background(), webrtc.RTPCodecTypeVideo.String())
+			return err
+		}
+
+		if err = s.recorder.Write(buf); err != nil {
+			s.errorStream <- fmt.Errorf("h264Source recorder.Write error: %v", err)
+		}
+	}
+
+	// RTP has no decoding time so the access unit is packaged as is
+	s.hls.WriteAccessUnit(au, pts, dts)
+	return nil
+}
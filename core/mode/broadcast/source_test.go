@@ -0,0 +1,68 @@
+package broadcast
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func TestSourceVideoCapability(t *testing.T) {
+	tests := []struct {
+		name  string
+		sps   []byte
+		fmtp  string
+		fails bool
+	}{
+		{
+			name: "constrained baseline",
+			sps:  []byte{0x67, 0x42, 0xc0, 0x1f, 0xda},
+			fmtp: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
+		},
+		{
+			name: "baseline",
+			sps:  []byte{0x67, 0x42, 0x00, 0x1e},
+			fmtp: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42001e",
+		},
+		{
+			name: "main with constraints",
+			sps:  []byte{0x67, 0x4d, 0x40, 0x28},
+			fmtp: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=4d0028",
+		},
+		{
+			name: "high",
+			sps:  []byte{0x27, 0x64, 0x00, 0x33},
+			fmtp: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=640033",
+		},
+		{
+			name:  "not an sps",
+			sps:   []byte{0x68, 0x42, 0xc0, 0x1f},
+			fails: true,
+		},
+		{
+			name:  "truncated",
+			sps:   []byte{0x67, 0x42, 0xc0},
+			fails: true,
+		},
+		{
+			name:  "empty",
+			fails: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			capability, err := sourceVideoCapability(test.sps)
+			if (err != nil) != test.fails {
+				t.Fatalf("got error %v, want failure %v", err, test.fails)
+			}
+
+			if test.fails {
+				return
+			}
+
+			if capability.MimeType != webrtc.MimeTypeH264 || capability.ClockRate != h264ClockRate || capability.SDPFmtpLine != test.fmtp {
+				t.Fatalf("got %+v, want %s", capability, test.fmtp)
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package broadcast
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/stats"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	broadcasterPeer = "broadcaster"
+
+	statsStreamInterval = 1 * time.Second
+	statsTokenKey       = "STATS_TOKEN"
+)
+
+// streamStats are the RTP stats of one track sent or received by a peer connection.
+// Jitter and round trip times are in seconds.
+type streamStats struct {
+	SSRC      uint32 `json:"ssrc"`
+	Kind      string `json:"kind"`
+	Direction string `json:"direction"`
+	// Inbound streams i.e. from the broadcaster
+	PacketsReceived uint64 `json:"packetsReceived,omitempty"`
+	BytesReceived   uint64 `json:"bytesReceived,omitempty"`
+	// Outbound streams i.e. to the participants
+	PacketsSent uint64 `json:"packetsSent,omitempty"`
+	BytesSent   uint64 `json:"bytesSent,omitempty"`
+	// Lost and jitter are measured by this instance for inbound streams and reported by the
+	// participant (RTCP receiver reports) for outbound streams
+	PacketsLost   int64   `json:"packetsLost"`
+	Jitter        float64 `json:"jitter"`
+	RoundTripTime float64 `json:"roundTripTime"`
+	// Sent by this instance for inbound streams and received from the participant for outbound streams
+	NACKCount uint32 `json:"nackCount"`
+	PLICount  uint32 `json:"pliCount"`
+	FIRCount  uint32 `json:"firCount"`
+}
+
+type candidateStats struct {
+	Address  string `json:"address"`
+	Port     uint16 `json:"port"`
+	Protocol string `json:"protocol"`
+	Type     string `json:"type"`
+}
+
+type candidatePairStats struct {
+	Local         candidateStats `json:"local"`
+	Remote        candidateStats `json:"remote"`
+	RoundTripTime float64        `json:"roundTripTime"`
+}
+
+// peerReport is a snapshot of a broadcaster or participant peer connection
+type peerReport struct {
+	ID            string              `json:"id"`
+	Role          string              `json:"role"`
+	State         string              `json:"state"`
+	Streams       []streamStats       `json:"streams"`
+	CandidatePair *candidatePairStats `json:"candidatePair,omitempty"`
+}
+
+// broadcastReport is a snapshot of all the peer connections of a live broadcast.
+// RTSP and RTMP broadcasters have no peer connection so they are not reported.
+type broadcastReport struct {
+	ID           string       `json:"id"`
+	Timestamp    time.Time    `json:"timestamp"`
+	Broadcaster  *peerReport  `json:"broadcaster,omitempty"`
+	Participants []peerReport `json:"participants"`
+}
+
+// peerStats collects the stats of a peer connection. The RTP stream stats come from the stats
+// interceptor because `GetStats` only reports the ICE transport in pion.
+type peerStats struct {
+	mutex          sync.Mutex
+	getter         stats.Getter
+	peerConnection *webrtc.PeerConnection
+}
+
+// newPeerStats adds the stats interceptor to the registry. The peer connection must be set
+// once it is created from the registry.
+func newPeerStats(i *interceptor.Registry) (*peerStats, error) {
+	factory, err := stats.NewInterceptor()
+	if err != nil {
+		return nil, fmt.Errorf("stats.NewInterceptor error: %v", err)
+	}
+
+	p := &peerStats{}
+	factory.OnNewPeerConnection(func(_ string, getter stats.Getter) {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		p.getter = getter
+	})
+	i.Add(factory)
+
+	return p, nil
+}
+
+func (p *peerStats) setPeerConnection(peerConnection *webrtc.PeerConnection) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.peerConnection = peerConnection
+}
+
+// Report returns the current stats of the peer connection
+func (p *peerStats) Report(id string, role string) peerReport {
+	p.mutex.Lock()
+	getter, peerConnection := p.getter, p.peerConnection
+	p.mutex.Unlock()
+
+	report := peerReport{
+		ID:      id,
+		Role:    role,
+		Streams: []streamStats{},
+	}
+	if getter == nil || peerConnection == nil {
+		return report
+	}
+
+	report.State = peerConnection.ConnectionState().String()
+
+	for _, receiver := range peerConnection.GetReceivers() {
+		for _, remoteTrack := range receiver.Tracks() {
+			s := getter.Get(uint32(remoteTrack.SSRC()))
+			if s == nil {
+				continue
+			}
+
+			clockRate := remoteTrack.Codec().ClockRate
+			report.Streams = append(report.Streams, streamStats{
+				SSRC:            uint32(remoteTrack.SSRC()),
+				Kind:            remoteTrack.Kind().String(),
+				Direction:       "inbound",
+				PacketsReceived: s.InboundRTPStreamStats.PacketsReceived,
+				BytesReceived:   s.InboundRTPStreamStats.BytesReceived,
+				PacketsLost:     s.InboundRTPStreamStats.PacketsLost,
+				Jitter:          jitterSeconds(s.InboundRTPStreamStats.Jitter, clockRate),
+				RoundTripTime:   s.RemoteOutboundRTPStreamStats.RoundTripTime.Seconds(),
+				NACKCount:       s.InboundRTPStreamStats.NACKCount,
+				PLICount:        s.InboundRTPStreamStats.PLICount,
+				FIRCount:        s.InboundRTPStreamStats.FIRCount,
+			})
+		}
+	}
+
+	for _, sender := range peerConnection.GetSenders() {
+		localTrack := sender.Track()
+		if localTrack == nil {
+			continue
+		}
+
+		for _, encoding := range sender.GetParameters().Encodings {
+			s := getter.Get(uint32(encoding.SSRC))
+			if s == nil {
+				continue
+			}
+
+			report.Streams = append(report.Streams, streamStats{
+				SSRC:          uint32(encoding.SSRC),
+				Kind:          localTrack.Kind().String(),
+				Direction:     "outbound",
+				PacketsSent:   s.OutboundRTPStreamStats.PacketsSent,
+				BytesSent:     s.OutboundRTPStreamStats.BytesSent,
+				PacketsLost:   s.RemoteInboundRTPStreamStats.PacketsLost,
+				Jitter:        s.RemoteInboundRTPStreamStats.Jitter,
+				RoundTripTime: s.RemoteInboundRTPStreamStats.RoundTripTime.Seconds(),
+				NACKCount:     s.OutboundRTPStreamStats.NACKCount,
+				PLICount:      s.OutboundRTPStreamStats.PLICount,
+				FIRCount:      s.OutboundRTPStreamStats.FIRCount,
+			})
+		}
+	}
+
+	report.CandidatePair = selectedCandidatePair(peerConnection)
+	return report
+}
+
+// selectedCandidatePair returns the ICE candidate pair the media flows on if connected
+func selectedCandidatePair(peerConnection *webrtc.PeerConnection) *candidatePairStats {
+	iceTransport := peerConnection.SCTP().Transport().ICETransport()
+	pair, err := iceTransport.GetSelectedCandidatePair()
+	if err != nil || pair == nil {
+		return nil
+	}
+
+	selected := &candidatePairStats{
+		Local:  toCandidateStats(pair.Local),
+		Remote: toCandidateStats(pair.Remote),
+	}
+
+	if s, ok := iceTransport.GetSelectedCandidatePairStats(); ok {
+		selected.RoundTripTime = s.CurrentRoundTripTime
+	}
+
+	return selected
+}
+
+func toCandidateStats(candidate *webrtc.ICECandidate) candidateStats {
+	if candidate == nil {
+		return candidateStats{}
+	}
+
+	return candidateStats{
+		Address:  candidate.Address,
+		Port:     candidate.Port,
+		Protocol: candidate.Protocol.String(),
+		Type:     candidate.Typ.String(),
+	}
+}
+
+// jitterSeconds converts the jitter measured by the interceptor from RTP timestamp units to seconds.
+// The jitter from the receiver reports is already in seconds.
+func jitterSeconds(jitter float64, clockRate uint32) float64 {
+	if clockRate == 0 {
+		return 0
+	}
+
+	return jitter / float64(clockRate)
+}
+
+// reportBroadcast returns the stats of the broadcaster and all its participants
+func reportBroadcast(b broadcastSession) broadcastReport {
+	report := broadcastReport{
+		ID:           b.ID,
+		Timestamp:    time.Now(),
+		Participants: []peerReport{},
+	}
+
+	if b.Stats != nil {
+		broadcaster := b.Stats.Report(b.ID, broadcasterPeer)
+		report.Broadcaster = &broadcaster
+	}
+
+	participants := []*participantSession{}
+	for _, p := range b.Participants {
+		if p.Stats != nil {
+			participants = append(participants, p)
+		}
+	}
+
+	sort.Slice(participants, func(i, j int) bool {
+		return participants[i].JoinedAt.Before(participants[j].JoinedAt)
+	})
+
+	for _, p := range participants {
+		report.Participants = append(report.Participants, p.Stats.Report(p.ID, p.Kind))
+	}
+
+	return report
+}
+
+// statsAuth only lets requests bearing the STATS_TOKEN (or the ADMIN_TOKEN) through to the
+// handler. The stats are read-only so they get their own token to share with whoever chases
+// quality issues.
+func statsAuth(handler gin.HandlerFunc) gin.HandlerFunc {
+	return tokenAuth(handler, statsTokenKey, adminTokenKey)
+}
+
+// statsHandler returns the WebRTC stats of the broadcaster and every participant
+func statsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		b, ok := findBroadcast(c.Param("id"))
+		if !ok {
+			c.String(http.StatusNotFound, "broadcast not found")
+			return
+		}
+
+		c.JSON(http.StatusOK, reportBroadcast(b))
+	}
+}
+
+// statsStreamHandler streams the WebRTC stats as Server-Sent Events every second until
+// the client disconnects or the broadcast ends
+func statsStreamHandler(canxCtx context.Context) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		broadcastID := c.Param("id")
+		b, ok := findBroadcast(broadcastID)
+		if !ok {
+			c.String(http.StatusNotFound, "broadcast not found")
+			return
+		}
+
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+
+		ticker := time.NewTicker(statsStreamInterval)
+		defer ticker.Stop()
+
+		c.SSEvent("stats", reportBroadcast(b))
+		c.Stream(func(_ io.Writer) bool {
+			select {
+			case <-canxCtx.Done():
+				return false
+			case <-c.Request.Context().Done():
+				return false
+			case <-b.RequestCtx.Done():
+				c.SSEvent("ended", gin.H{"id": broadcastID})
+				return false
+			case <-ticker.C:
+				// Participants come and go so the broadcast is looked up every time
+				b, ok = findBroadcast(broadcastID)
+				if !ok {
+					c.SSEvent("ended", gin.H{"id": broadcastID})
+					return false
+				}
+
+				c.SSEvent("stats", reportBroadcast(b))
+				return true
+			}
+		})
+	}
+}
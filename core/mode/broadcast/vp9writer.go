@@ -0,0 +1,155 @@
+package broadcast
+
+import (
+	"encoding/binary"
+	"os"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+)
+
+const (
+	ivfHeaderSize      = 32
+	ivfFrameHeaderSize = 12
+	vp9ClockRate       = 90000
+)
+
+// Reference:
+// https://wiki.multimedia.cx/index.php/Duck_IVF
+// vp9Writer writes the VP9 RTP packets of a track to an IVF file since the pion IVF writer only
+// supports VP8 and AV1. Frames are written from the first keyframe on and a frame missing a
+// packet is dropped. The frame timestamps are in the RTP clock (the IVF timebase).
+type vp9Writer struct {
+	file *os.File
+
+	frame        []byte
+	inFrame      bool
+	seenKeyframe bool
+	firstTS      uint32
+	lastSeq      uint16
+	count        uint32
+	width        uint16
+	height       uint16
+}
+
+func newVP9Writer(path string) (*vp9Writer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, ivfHeaderSize)
+	copy(header[0:], "DKIF")
+	binary.LittleEndian.PutUint16(header[4:], 0)             // Version
+	binary.LittleEndian.PutUint16(header[6:], ivfHeaderSize) // Header size
+	copy(header[8:], "VP90")
+	// The dimensions and the frame count are updated on close
+	binary.LittleEndian.PutUint32(header[16:], vp9ClockRate) // Timebase denominator
+	binary.LittleEndian.PutUint32(header[20:], 1)            // Timebase numerator
+
+	_, err = file.Write(header)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return &vp9Writer{
+		file: file,
+	}, nil
+}
+
+// WriteRTP adds the packet to the current frame and writes the frame once complete
+func (w *vp9Writer) WriteRTP(packet *rtp.Packet) error {
+	if len(packet.Payload) == 0 {
+		return nil
+	}
+
+	vp9 := codecs.VP9Packet{}
+	_, err := vp9.Unmarshal(packet.Payload)
+	if err != nil {
+		return err
+	}
+
+	// A lost packet makes the frame undecodable
+	if w.inFrame && packet.SequenceNumber != w.lastSeq+1 {
+		w.inFrame = false
+	}
+	w.lastSeq = packet.SequenceNumber
+
+	if vp9.B {
+		// Frames are only decodable from a keyframe onwards
+		if !w.seenKeyframe && vp9.P {
+			return nil
+		}
+
+		if !w.seenKeyframe {
+			w.seenKeyframe = true
+			w.firstTS = packet.Timestamp
+		}
+
+		w.frame = w.frame[:0]
+		w.inFrame = true
+	}
+
+	if !w.inFrame {
+		return nil
+	}
+
+	// Keyframes carry the resolution in the scalability structure
+	if vp9.V && vp9.Y && len(vp9.Width) > 0 {
+		w.width, w.height = vp9.Width[0], vp9.Height[0]
+	}
+
+	w.frame = append(w.frame, vp9.Payload...)
+	if !vp9.E && !packet.Marker {
+		return nil
+	}
+	w.inFrame = false
+
+	frameHeader := make([]byte, ivfFrameHeaderSize)
+	binary.LittleEndian.PutUint32(frameHeader[0:], uint32(len(w.frame)))
+	binary.LittleEndian.PutUint64(frameHeader[4:], uint64(packet.Timestamp-w.firstTS))
+
+	_, err = w.file.Write(frameHeader)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.file.Write(w.frame)
+	if err != nil {
+		return err
+	}
+
+	w.count++
+	return nil
+}
+
+// Close updates the header and closes the file
+func (w *vp9Writer) Close() error {
+	if w.file == nil {
+		return nil
+	}
+
+	defer func() {
+		w.file = nil
+	}()
+
+	header := make([]byte, 4)
+	binary.LittleEndian.PutUint16(header[0:], w.width)
+	binary.LittleEndian.PutUint16(header[2:], w.height)
+	_, err := w.file.WriteAt(header, 12)
+	if err != nil {
+		_ = w.file.Close()
+		return err
+	}
+
+	count := make([]byte, 4)
+	binary.LittleEndian.PutUint32(count, w.count)
+	_, err = w.file.WriteAt(count, 24)
+	if err != nil {
+		_ = w.file.Close()
+		return err
+	}
+
+	return w.file.Close()
+}
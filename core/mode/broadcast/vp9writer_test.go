@@ -0,0 +1,148 @@
+package broadcast
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4/pkg/media/ivfreader"
+)
+
+// vp9RTP returns a packet with the VP9 payload descriptor flags (no optional fields)
+func vp9RTP(seq uint16, ts uint32, predicted, begin, end bool, payload ...byte) *rtp.Packet {
+	descriptor := byte(0)
+	if predicted {
+		descriptor |= 0x40
+	}
+	if begin {
+		descriptor |= 0x08
+	}
+	if end {
+		descriptor |= 0x04
+	}
+
+	return &rtp.Packet{
+		Header: rtp.Header{
+			SequenceNumber: seq,
+			Timestamp:      ts,
+			Marker:         end,
+		},
+		Payload: append([]byte{descriptor}, payload...),
+	}
+}
+
+func TestVP9Writer(t *testing.T) {
+	type frame struct {
+		timestamp uint64
+		data      []byte
+	}
+
+	tests := []struct {
+		name    string
+		packets []*rtp.Packet
+		frames  []frame
+	}{
+		{
+			name: "frames",
+			packets: []*rtp.Packet{
+				vp9RTP(1, 1000, false, true, true, 0x01, 0x02),
+				vp9RTP(2, 4000, true, true, false, 0x03),
+				vp9RTP(3, 4000, true, false, true, 0x04),
+			},
+			frames: []frame{
+				{timestamp: 0, data: []byte{0x01, 0x02}},
+				{timestamp: 3000, data: []byte{0x03, 0x04}},
+			},
+		},
+		{
+			name: "predicted frames before the keyframe are dropped",
+			packets: []*rtp.Packet{
+				vp9RTP(1, 1000, true, true, true, 0x01),
+				vp9RTP(2, 4000, false, true, true, 0x02),
+			},
+			frames: []frame{
+				{timestamp: 0, data: []byte{0x02}},
+			},
+		},
+		{
+			name: "frames missing a packet are dropped",
+			packets: []*rtp.Packet{
+				vp9RTP(1, 1000, false, true, true, 0x01),
+				vp9RTP(2, 4000, true, true, false, 0x02),
+				vp9RTP(4, 4000, true, false, true, 0x03),
+				vp9RTP(5, 7000, true, true, true, 0x04),
+			},
+			frames: []frame{
+				{timestamp: 0, data: []byte{0x01}},
+				{timestamp: 6000, data: []byte{0x04}},
+			},
+		},
+		{
+			name: "timestamps wrap around",
+			packets: []*rtp.Packet{
+				vp9RTP(65535, 0xffffff00, false, true, true, 0x01),
+				vp9RTP(0, 0x00000100, true, true, true, 0x02),
+			},
+			frames: []frame{
+				{timestamp: 0, data: []byte{0x01}},
+				{timestamp: 0x200, data: []byte{0x02}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "video.ivf")
+			writer, err := newVP9Writer(path)
+			if err != nil {
+				t.Fatalf("newVP9Writer error: %v", err)
+			}
+
+			for _, packet := range test.packets {
+				if err = writer.WriteRTP(packet); err != nil {
+					t.Fatalf("WriteRTP error: %v", err)
+				}
+			}
+
+			if err = writer.Close(); err != nil {
+				t.Fatalf("Close error: %v", err)
+			}
+
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatalf("open error: %v", err)
+			}
+			defer file.Close()
+
+			reader, header, err := ivfreader.NewWith(file)
+			if err != nil {
+				t.Fatalf("ivfreader.NewWith error: %v", err)
+			}
+
+			if header.FourCC != "VP90" || header.TimebaseDenominator != vp9ClockRate || header.TimebaseNumerator != 1 {
+				t.Fatalf("unexpected header %+v", header)
+			}
+
+			if int(header.NumFrames) != len(test.frames) {
+				t.Fatalf("header has %d frames, want %d", header.NumFrames, len(test.frames))
+			}
+
+			for _, want := range test.frames {
+				data, frameHeader, err := reader.ParseNextFrame()
+				if err != nil {
+					t.Fatalf("ParseNextFrame error: %v", err)
+				}
+
+				if frameHeader.Timestamp != want.timestamp || !bytes.Equal(data, want.data) {
+					t.Fatalf("got frame %d %x, want %d %x", frameHeader.Timestamp, data, want.timestamp, want.data)
+				}
+			}
+
+			if _, _, err = reader.ParseNextFrame(); err == nil {
+				t.Fatalf("got more frames than %d", len(test.frames))
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package broadcast
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/khaledhikmat/family-meeting/service/lgr"
+)
+
+// Reference:
+// https://www.ietf.org/archive/id/draft-ietf-wish-whep-01.html
+// whepHandler accepts an SDP offer from a viewer for a live broadcast on this instance and
+// responds with the SDP answer. The viewer is fed from the broadcaster local tracks just like
+// Firestore-driven participants.
+func whepHandler(canxCtx context.Context,
+	errorStream chan error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowCORS(c)
+
+		if c.ContentType() != sdpContentType {
+			c.String(http.StatusUnsupportedMediaType, "content type must be %s", sdpContentType)
+			return
+		}
+
+		broadcastID := c.Param("id")
+		broadcast, ok := sessions.getBroadcast(broadcastID)
+		if !ok || len(broadcast.Tracks) == 0 {
+			c.String(http.StatusNotFound, "broadcast not found")
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil || len(body) == 0 {
+			c.String(http.StatusBadRequest, "missing SDP offer")
+			return
+		}
+
+		offer := webrtc.SessionDescription{
+			Type: webrtc.SDPTypeOffer,
+			SDP:  string(body),
+		}
+
+		participantID, err := newSessionID()
+		if err != nil {
+			c.String(http.StatusInternalServerError, "unable to create session")
+			return
+		}
+
+		if reason, ok := capacity.admitParticipant(broadcastID); !ok {
+			c.String(http.StatusServiceUnavailable, reason)
+			return
+		}
+
+		participantCanxCtx, participantCanxFn := context.WithCancel(broadcast.RequestCtx)
+
+		peerConnection, stats, err := newParticipantConnection(canxCtx, participantCanxCtx, participantCanxFn, errorStream, offer, broadcast.Tracks, nil)
+		if err != nil {
+			capacity.releaseParticipant(broadcastID)
+			participantCanxFn()
+			errorStream <- fmt.Errorf("whepHandler %v", err)
+			c.String(http.StatusBadRequest, "unable to negotiate: %v", err)
+			return
+		}
+
+		lgr.Logger.Info("whepHandler started a participant",
+			slog.String("broadcast", broadcastID),
+			slog.String("participant", participantID),
+		)
+
+		go func() {
+			defer capacity.releaseParticipant(broadcastID)
+			defer participantCanxFn()
+			defer func() {
+				if cErr := peerConnection.Close(); cErr != nil {
+					errorStream <- fmt.Errorf("whepHandler cannot close peerConnection: %v", cErr)
+				}
+			}()
+
+			runParticipant(canxCtx, participantCanxCtx, participantCanxFn, broadcastID, participantID, whepParticipant, stats)
+		}()
+
+		c.Header("Location", fmt.Sprintf("/whep/%s/%s", broadcastID, participantID))
+		c.Data(http.StatusCreated, sdpContentType, []byte(peerConnection.LocalDescription().SDP))
+	}
+}
+
+// whepDeleteHandler tears a WHEP viewer down
+func whepDeleteHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowCORS(c)
+
+		if !sessions.cancelParticipant(c.Param("id"), c.Param("session")) {
+			c.String(http.StatusNotFound, "session not found")
+			return
+		}
+
+		c.Status(http.StatusOK)
+	}
+}
+
+// optionsHandler answers CORS pre-flight requests so browser-based players can use WHIP/WHEP
+func optionsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowCORS(c)
+		c.Header("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func allowCORS(c *gin.Context) {
+	c.Header("Access-Control-Allow-Origin", "*")
+	c.Header("Access-Control-Expose-Headers", "Location")
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,152 @@
+package broadcast
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/khaledhikmat/family-meeting/service/lgr"
+	"github.com/khaledhikmat/family-meeting/service/signaling"
+	"github.com/khaledhikmat/family-meeting/service/storage"
+	"github.com/khaledhikmat/family-meeting/utils"
+)
+
+const (
+	sdpContentType = "application/sdp"
+	whipRequestor  = "whip"
+	whipTokenKey   = "WHIP_TOKEN"
+)
+
+// whipAuth only lets requests bearing the WHIP_TOKEN (or the ADMIN_TOKEN) through to the handler.
+// The WHIP spec authenticates the broadcasters using a bearer token i.e. the OBS stream key.
+// It also guards the other ingest endpoints and the recording endpoints since they start
+// broadcasts or record them.
+func whipAuth(handler gin.HandlerFunc) gin.HandlerFunc {
+	return tokenAuth(handler, whipTokenKey, adminTokenKey)
+}
+
+// Reference:
+// https://www.ietf.org/archive/id/draft-ietf-wish-whip-16.html
+// whipHandler accepts an SDP offer from a WHIP-capable broadcaster (i.e. OBS) and responds
+// with the SDP answer. The broadcast is then run by the same pipeline as Firestore-driven
+// broadcasts so participants can join using the returned broadcast ID.
+func whipHandler(canxCtx context.Context,
+	store signaling.SignalingStore,
+	sink storage.RecordingSink,
+	errorStream chan error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowCORS(c)
+
+		if c.ContentType() != sdpContentType {
+			c.String(http.StatusUnsupportedMediaType, "content type must be %s", sdpContentType)
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil || len(body) == 0 {
+			c.String(http.StatusBadRequest, "missing SDP offer")
+			return
+		}
+
+		offer := webrtc.SessionDescription{
+			Type: webrtc.SDPTypeOffer,
+			SDP:  string(body),
+		}
+
+		if !capacity.admitBroadcast() {
+			c.String(http.StatusServiceUnavailable, "instance is at capacity")
+			return
+		}
+
+		requestCanxCtx, requestCanxFn := context.WithCancel(canxCtx)
+		localTrackStream := make(chan track)
+
+		// The broadcast ID is bound to the metrics once the request is created
+		metrics := newMediaMetrics("")
+		peerConnection, stats, err := newBroadcasterConnection(canxCtx, requestCanxCtx, errorStream, offer, localTrackStream, metrics, nil)
+		if err != nil {
+			capacity.releaseBroadcast()
+			requestCanxFn()
+			close(localTrackStream)
+			errorStream <- fmt.Errorf("whipHandler %v", err)
+			c.String(http.StatusBadRequest, "unable to negotiate: %v", err)
+			return
+		}
+
+		// Record the broadcast so participants and aborts work the same way
+		// The answer is already set so the monitor does not dispatch it
+		broadcastID, err := store.CreateRequest(c, utils.Request{
+			Requestor:   whipRequestor,
+			Kind:        "broadcaster",
+			Offer:       utils.Encode(&offer),
+			Answer:      utils.Encode(peerConnection.LocalDescription()),
+			Status:      utils.StatusNegotiating,
+			Transitions: []utils.Transition{signaling.NewTransition(utils.StatusNegotiating, "")},
+		})
+		if err != nil {
+			capacity.releaseBroadcast()
+			requestCanxFn()
+			close(localTrackStream)
+			if cErr := peerConnection.Close(); cErr != nil {
+				errorStream <- fmt.Errorf("whipHandler cannot close peerConnection: %v", cErr)
+			}
+			errorStream <- fmt.Errorf("whipHandler store.CreateRequest error: %v", err)
+			c.String(http.StatusInternalServerError, "unable to create broadcast")
+			return
+		}
+
+		metrics.bind(broadcastID)
+
+		lgr.Logger.Info("whipHandler started a broadcast",
+			slog.String("broadcast", broadcastID),
+		)
+
+		go func() {
+			defer capacity.releaseBroadcast()
+			defer requestCanxFn()
+			defer close(localTrackStream)
+			defer func() {
+				if cErr := peerConnection.Close(); cErr != nil {
+					errorStream <- fmt.Errorf("whipHandler cannot close peerConnection: %v", cErr)
+				}
+			}()
+
+			runBroadcaster(canxCtx, requestCanxCtx, requestCanxFn, errorStream, store, sink, broadcastID, localTrackStream, expectedTracks(offer), stats)
+		}()
+
+		c.Header("Location", "/whip/"+broadcastID)
+		c.Data(http.StatusCreated, sdpContentType, []byte(peerConnection.LocalDescription().SDP))
+	}
+}
+
+// whipDeleteHandler ends a WHIP broadcast by aborting its request. Only the broadcasts created
+// by the WHIP endpoint can be ended this way.
+func whipDeleteHandler(store signaling.SignalingStore,
+	errorStream chan error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowCORS(c)
+
+		broadcastID := c.Param("id")
+		request, err := store.GetRequest(c, broadcastID)
+		if err != nil || request.Kind != "broadcaster" || request.Requestor != whipRequestor {
+			c.String(http.StatusNotFound, "broadcast not found")
+			return
+		}
+
+		err = store.UpdateRequest(c, broadcastID, map[string]interface{}{
+			"abort": true,
+		})
+		if err != nil {
+			errorStream <- fmt.Errorf("whipDeleteHandler store.UpdateRequest error: %v", err)
+			c.String(http.StatusInternalServerError, "unable to abort broadcast")
+			return
+		}
+
+		c.Status(http.StatusOK)
+	}
+}
@@ -0,0 +1,340 @@
+package loadtest
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/khaledhikmat/family-meeting/service/dispatch"
+	"github.com/khaledhikmat/family-meeting/service/ice"
+	"github.com/khaledhikmat/family-meeting/service/lgr"
+	"github.com/khaledhikmat/family-meeting/service/signaling"
+	"github.com/khaledhikmat/family-meeting/service/storage"
+	"github.com/khaledhikmat/family-meeting/utils"
+)
+
+const (
+	loadtestRequestor   = "loadtest"
+	defaultParticipants = 10
+	defaultDuration     = 30 * time.Second
+	defaultRamp         = 100 * time.Millisecond
+)
+
+type options struct {
+	broadcastID  string
+	participants int
+	duration     time.Duration
+	ramp         time.Duration
+}
+
+// result is what one participant measured
+type result struct {
+	Index       int
+	ID          string
+	JoinLatency time.Duration
+	FirstFrame  time.Duration
+	Received    uint64
+	Lost        uint64
+	Bitrate     float64
+	Err         error
+}
+
+// Processor joins a broadcast with N headless participants (the same way as `participant.js`
+// through the signaling store), consumes their RTP and reports join latency, time-to-first-frame,
+// packet loss and bitrate per participant.
+// i.e. `loadtest --broadcast <id> [--participants 10] [--duration 30s] [--ramp 100ms]`
+func Processor(canxCtx context.Context,
+	store signaling.SignalingStore,
+	_ dispatch.Dispatcher,
+	_ storage.RecordingSink,
+	errorStream chan error) error {
+
+	lgr.Logger.Info("loadtest proc started")
+
+	opts, err := parseOptions(os.Args[2:])
+	if err != nil {
+		return err
+	}
+
+	results := make([]result, opts.participants)
+	wg := sync.WaitGroup{}
+
+	// Ramp up the participants so the signaling store is not flooded
+	for i := 0; i < opts.participants; i++ {
+		if i > 0 {
+			select {
+			case <-canxCtx.Done():
+				goto report
+			case <-time.After(opts.ramp):
+			}
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = runParticipant(canxCtx, store, errorStream, opts, i)
+		}(i)
+	}
+
+report:
+	wg.Wait()
+	report(results)
+
+	lgr.Logger.Info("loadtest proc completed")
+	return nil
+}
+
+func parseOptions(args []string) (options, error) {
+	opts := options{}
+	flags := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	flags.StringVar(&opts.broadcastID, "broadcast", "", "Broadcast ID to join")
+	flags.IntVar(&opts.participants, "participants", defaultParticipants, "Number of participants")
+	flags.DurationVar(&opts.duration, "duration", defaultDuration, "How long each participant stays")
+	flags.DurationVar(&opts.ramp, "ramp", defaultRamp, "Delay between participants joining")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return opts, err
+	}
+
+	if opts.broadcastID == "" {
+		return opts, fmt.Errorf("loadtest requires --broadcast")
+	}
+
+	if opts.participants < 1 {
+		return opts, fmt.Errorf("loadtest requires at least 1 participant")
+	}
+
+	return opts, nil
+}
+
+// runParticipant joins the broadcast, stays for the test duration and returns its measurements
+func runParticipant(canxCtx context.Context,
+	store signaling.SignalingStore,
+	errorStream chan error,
+	opts options,
+	index int) result {
+	res := result{Index: index}
+
+	participantCanxCtx, participantCanxFn := context.WithTimeout(canxCtx, opts.duration)
+	defer participantCanxFn()
+
+	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{
+		ICEServers: ice.Servers(loadtestRequestor),
+	})
+	if err != nil {
+		res.Err = fmt.Errorf("NewPeerConnection error: %v", err)
+		return res
+	}
+	defer func() {
+		if cErr := peerConnection.Close(); cErr != nil {
+			errorStream <- fmt.Errorf("runParticipant cannot close peerConnection: %v", cErr)
+		}
+	}()
+
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
+		if _, err = peerConnection.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{
+			Direction: webrtc.RTPTransceiverDirectionRecvonly,
+		}); err != nil {
+			res.Err = fmt.Errorf("AddTransceiverFromKind error: %v", err)
+			return res
+		}
+	}
+
+	start := time.Now()
+	stats := newParticipantStats(start)
+
+	peerConnection.OnTrack(func(remoteTrack *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		video := remoteTrack.Kind() == webrtc.RTPCodecTypeVideo
+		for {
+			packet, _, err := remoteTrack.ReadRTP()
+			if err != nil {
+				return
+			}
+			stats.Add(remoteTrack.SSRC(), video, packet.SequenceNumber, packet.Marker, len(packet.Payload))
+		}
+	})
+
+	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateConnected {
+			stats.Joined()
+		}
+	})
+
+	// Candidates are trickled once the request exists
+	requestID := ""
+	pendingCandidates := []webrtc.ICECandidateInit{}
+	candidatesMux := sync.Mutex{}
+	addCandidate := func(candidate webrtc.ICECandidateInit) {
+		err := store.AddCandidate(canxCtx, requestID, utils.OfferCandidates, utils.EncodeCandidate(&candidate))
+		if err != nil {
+			errorStream <- fmt.Errorf("runParticipant store.AddCandidate error: %v", err)
+		}
+	}
+
+	peerConnection.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate == nil {
+			return
+		}
+
+		candidatesMux.Lock()
+		defer candidatesMux.Unlock()
+		if requestID == "" {
+			pendingCandidates = append(pendingCandidates, candidate.ToJSON())
+			return
+		}
+		addCandidate(candidate.ToJSON())
+	})
+
+	offer, err := peerConnection.CreateOffer(nil)
+	if err != nil {
+		res.Err = fmt.Errorf("CreateOffer error: %v", err)
+		return res
+	}
+
+	err = peerConnection.SetLocalDescription(offer)
+	if err != nil {
+		res.Err = fmt.Errorf("SetLocalDescription error: %v", err)
+		return res
+	}
+
+	id, err := store.CreateRequest(canxCtx, utils.Request{
+		Parent:    opts.broadcastID,
+		Requestor: loadtestRequestor,
+		Kind:      "participant",
+		Offer:     utils.Encode(&offer),
+	})
+	if err != nil {
+		res.Err = fmt.Errorf("store.CreateRequest error: %v", err)
+		return res
+	}
+	res.ID = id
+
+	// Leave the broadcast when done
+	defer func() {
+		err := store.UpdateRequest(context.Background(), id, map[string]interface{}{
+			"abort": true,
+		})
+		if err != nil {
+			errorStream <- fmt.Errorf("runParticipant store.UpdateRequest error: %v", err)
+		}
+	}()
+
+	candidatesMux.Lock()
+	requestID = id
+	for _, candidate := range pendingCandidates {
+		addCandidate(candidate)
+	}
+	candidatesMux.Unlock()
+
+	answer := signaling.WaitForAnswer(canxCtx, participantCanxCtx, errorStream, store, id)
+	if answer.SDP == "" {
+		res.Err = fmt.Errorf("no answer in %v", opts.duration)
+		if request, err := store.GetRequest(context.Background(), id); err == nil && request.Rejection != "" {
+			res.Err = fmt.Errorf("rejected: %s", request.Rejection)
+		}
+		return res
+	}
+
+	err = peerConnection.SetRemoteDescription(answer)
+	if err != nil {
+		res.Err = fmt.Errorf("SetRemoteDescription error: %v", err)
+		return res
+	}
+
+	// Apply the candidates trickled by the core
+	go func() {
+		for candidate := range signaling.WatchCandidates(canxCtx, participantCanxCtx, errorStream, store, id, utils.AnswerCandidates) {
+			if err := peerConnection.AddICECandidate(candidate); err != nil {
+				errorStream <- fmt.Errorf("runParticipant AddICECandidate error: %v", err)
+			}
+		}
+	}()
+
+	<-participantCanxCtx.Done()
+
+	stats.Fill(&res)
+	if res.JoinLatency == 0 {
+		res.Err = fmt.Errorf("not connected in %v", opts.duration)
+	}
+
+	return res
+}
+
+// report logs the measurements of every participant followed by a summary
+func report(results []result) {
+	joins := []time.Duration{}
+	firstFrames := []time.Duration{}
+	var received, lost uint64
+	var bitrate float64
+	failed := 0
+
+	for _, res := range results {
+		if res.Err != nil {
+			failed++
+			lgr.Logger.Info("loadtest participant failed",
+				slog.Int("participant", res.Index),
+				slog.String("request", res.ID),
+				slog.String("error", res.Err.Error()),
+			)
+			continue
+		}
+
+		lgr.Logger.Info("loadtest participant",
+			slog.Int("participant", res.Index),
+			slog.String("request", res.ID),
+			slog.Duration("join_latency", res.JoinLatency),
+			slog.Duration("time_to_first_frame", res.FirstFrame),
+			slog.Uint64("received", res.Received),
+			slog.Uint64("lost", res.Lost),
+			slog.Float64("bitrate_kbps", res.Bitrate/1000),
+		)
+
+		joins = append(joins, res.JoinLatency)
+		if res.FirstFrame > 0 {
+			firstFrames = append(firstFrames, res.FirstFrame)
+		}
+		received += res.Received
+		lost += res.Lost
+		bitrate += res.Bitrate
+	}
+
+	lossRate := 0.0
+	if received+lost > 0 {
+		lossRate = float64(lost) / float64(received+lost) * 100
+	}
+
+	avgBitrate := 0.0
+	if len(joins) > 0 {
+		avgBitrate = bitrate / float64(len(joins))
+	}
+
+	lgr.Logger.Info("loadtest summary",
+		slog.Int("participants", len(results)),
+		slog.Int("failed", failed),
+		slog.Duration("join_latency_p50", percentile(joins, 50)),
+		slog.Duration("join_latency_p95", percentile(joins, 95)),
+		slog.Duration("time_to_first_frame_p50", percentile(firstFrames, 50)),
+		slog.Duration("time_to_first_frame_p95", percentile(firstFrames, 95)),
+		slog.Int("no_frame", len(joins)-len(firstFrames)),
+		slog.Float64("loss_percent", lossRate),
+		slog.Float64("avg_bitrate_kbps", avgBitrate/1000),
+	)
+}
+
+func percentile(values []time.Duration, p int) time.Duration {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	return sorted[(len(sorted)-1)*p/100]
+}
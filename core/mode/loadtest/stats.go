@@ -0,0 +1,121 @@
+package loadtest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// trackStats counts the packets of one remote track. Sequence numbers are extended so
+// wrap-arounds do not look like losses.
+type trackStats struct {
+	started  bool
+	baseSeq  int64
+	highest  int64
+	received uint64
+	bytes    uint64
+	first    time.Time
+	last     time.Time
+}
+
+func (t *trackStats) add(seq uint16, size int, now time.Time) {
+	if !t.started {
+		t.started = true
+		t.baseSeq = int64(seq)
+		t.highest = int64(seq)
+		t.first = now
+	}
+
+	// Extend the sequence number relative to the highest one seen
+	extended := t.highest + int64(int16(seq-uint16(t.highest)))
+	if extended > t.highest {
+		t.highest = extended
+	}
+
+	t.received++
+	t.bytes += uint64(size)
+	t.last = now
+}
+
+func (t *trackStats) lost() uint64 {
+	expected := uint64(t.highest - t.baseSeq + 1)
+	if !t.started || t.received >= expected {
+		return 0
+	}
+
+	return expected - t.received
+}
+
+// participantStats gathers the measurements of one participant across its tracks
+type participantStats struct {
+	mutex      sync.Mutex
+	start      time.Time
+	joined     time.Duration
+	firstFrame time.Duration
+	tracks     map[webrtc.SSRC]*trackStats
+}
+
+func newParticipantStats(start time.Time) *participantStats {
+	return &participantStats{
+		start:  start,
+		tracks: map[webrtc.SSRC]*trackStats{},
+	}
+}
+
+// Joined records the time it took to connect
+func (p *participantStats) Joined() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.joined == 0 {
+		p.joined = time.Since(p.start)
+	}
+}
+
+// Add records a received packet. The first frame is complete when the first video packet
+// with the marker bit arrives.
+func (p *participantStats) Add(ssrc webrtc.SSRC, video bool, seq uint16, marker bool, size int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	if video && marker && p.firstFrame == 0 {
+		p.firstFrame = now.Sub(p.start)
+	}
+
+	t, ok := p.tracks[ssrc]
+	if !ok {
+		t = &trackStats{}
+		p.tracks[ssrc] = t
+	}
+	t.add(seq, size, now)
+}
+
+// Fill copies the measurements into the result
+func (p *participantStats) Fill(res *result) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	res.JoinLatency = p.joined
+	res.FirstFrame = p.firstFrame
+
+	var bytes uint64
+	var first, last time.Time
+	for _, t := range p.tracks {
+		res.Received += t.received
+		res.Lost += t.lost()
+		bytes += t.bytes
+
+		if first.IsZero() || t.first.Before(first) {
+			first = t.first
+		}
+		if t.last.After(last) {
+			last = t.last
+		}
+	}
+
+	if elapsed := last.Sub(first).Seconds(); elapsed > 0 {
+		res.Bitrate = float64(bytes*8) / elapsed
+	}
+}
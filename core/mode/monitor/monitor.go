@@ -6,24 +6,23 @@ import (
 	"log/slog"
 	"time"
 
-	"cloud.google.com/go/firestore"
-	"cloud.google.com/go/pubsub"
-	firebase "firebase.google.com/go/v4"
 	"github.com/mdobak/go-xerrors"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/khaledhikmat/family-meeting/service/dispatch"
 	"github.com/khaledhikmat/family-meeting/service/lgr"
+	"github.com/khaledhikmat/family-meeting/service/signaling"
+	"github.com/khaledhikmat/family-meeting/service/storage"
 	"github.com/khaledhikmat/family-meeting/utils"
 )
 
-const (
-	projectID       = "family-meeting-aa853"
-	broadcastsTopic = "broadcasts"
-)
-
 var (
-	meter = otel.Meter("family.meeting.monitor")
+	meter  = otel.Meter("family.meeting.monitor")
+	tracer = otel.Tracer("family.meeting.monitor")
 
 	publishDuration metric.Int64Histogram
 )
@@ -44,46 +43,18 @@ func init() {
 }
 
 func Processor(canxCtx context.Context,
-	_ *firebase.App,
-	db *firestore.Client,
+	store signaling.SignalingStore,
+	dispatcher dispatch.Dispatcher,
+	_ storage.RecordingSink,
 	errorStream chan error) error {
 
 	lgr.Logger.Info("monitor proc started")
 
-	client, err := pubsub.NewClient(canxCtx, projectID)
-	if err != nil {
-		lgr.Logger.Error(
-			"creating pubsub client",
-			slog.Any("error", xerrors.New(err.Error())),
-		)
-		return err
-	}
-	defer client.Close()
-
-	t := client.Topic(broadcastsTopic)
-	defer t.Stop()
-
-	ok, err := t.Exists(canxCtx)
-	if err != nil {
-		lgr.Logger.Error(
-			"checking topic",
-			slog.String("topic", broadcastsTopic),
-			slog.Any("error", xerrors.New(err.Error())),
-		)
-		return err
-	}
-
-	if !ok {
-		lgr.Logger.Error(
-			"checking topic",
-			slog.String("topic", broadcastsTopic),
-			slog.Any("error", xerrors.New(err.Error())),
-		)
-		return err
-	}
-
 	// Monitor for broadcaster requests
-	broadcastReqStream := utils.MonitorRequests(canxCtx, nil, nil, errorStream, db, "broadcaster", "")
+	broadcastReqStream := store.WatchRequests(canxCtx, nil, errorStream, "broadcaster", "")
+
+	// Monitor for RTSP camera requests
+	rtspReqStream := store.WatchSourceRequests(canxCtx, nil, errorStream, "rtsp")
 
 	for {
 		select {
@@ -92,21 +63,72 @@ func Processor(canxCtx context.Context,
 				"monitor proc context cancelled",
 			)
 			return nil
-		case broadcastReqDoc := <-broadcastReqStream:
+		case broadcastReq, ok := <-broadcastReqStream:
+			if !ok {
+				lgr.Logger.Info(
+					"monitor proc broadcast request stream closed",
+				)
+				return nil
+			}
+
 			// Publish a message (as broadcast_request ID) to kick start a broadcaster
-			now := time.Now()
-			result := t.Publish(canxCtx, &pubsub.Message{
-				Data: []byte(broadcastReqDoc.Ref.ID),
-			})
-			id, err := result.Get(canxCtx)
-			if err != nil {
-				errorStream <- fmt.Errorf("error publishing message: %v", err)
+			dispatchRequest(canxCtx, errorStream, store, dispatcher, broadcastReq.ID, nil)
+		case rtspReq, ok := <-rtspReqStream:
+			if !ok {
+				lgr.Logger.Info(
+					"monitor proc rtsp request stream closed",
+				)
+				return nil
 			}
-			lgr.Logger.Info(
-				"monitor proc published message",
-				slog.String("broadcast_id", id),
-			)
-			publishDuration.Record(canxCtx, time.Since(now).Milliseconds())
+
+			// The kind attribute tells the broadcast processor to pull from the camera
+			dispatchRequest(canxCtx, errorStream, store, dispatcher, rtspReq.ID, map[string]string{
+				"kind": "rtsp",
+			})
 		}
 	}
 }
+
+// dispatchRequest publishes the request ID to the broadcast processors. It starts the broadcast
+// trace which is propagated in the message attributes.
+func dispatchRequest(canxCtx context.Context,
+	errorStream chan error,
+	store signaling.SignalingStore,
+	dispatcher dispatch.Dispatcher,
+	requestID string,
+	attributes map[string]string) {
+	kind := "broadcaster"
+	if attributes["kind"] != "" {
+		kind = attributes["kind"]
+	}
+
+	canxCtx, span := tracer.Start(canxCtx, "monitor.dispatch",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("broadcast", requestID),
+			attribute.String("kind", kind),
+		),
+	)
+	defer span.End()
+
+	// The status is moved first since a broadcaster may pick the message up right away
+	now := time.Now()
+	signaling.SetStatus(canxCtx, errorStream, store, requestID, utils.StatusDispatched, "")
+	id, err := dispatcher.Publish(canxCtx, &dispatch.Message{
+		Data:       []byte(requestID),
+		Attributes: attributes,
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "unable to dispatch")
+		errorStream <- fmt.Errorf("error publishing message: %v", err)
+		signaling.SetStatus(canxCtx, errorStream, store, requestID, utils.StatusFailed, "unable to dispatch")
+		return
+	}
+
+	lgr.Logger.InfoContext(canxCtx,
+		"monitor proc published message",
+		slog.String("broadcast_id", id),
+	)
+	publishDuration.Record(canxCtx, time.Since(now).Milliseconds())
+}
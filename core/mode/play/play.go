@@ -0,0 +1,444 @@
+package play
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+	"github.com/pion/webrtc/v4/pkg/media/ivfreader"
+	"github.com/pion/webrtc/v4/pkg/media/oggreader"
+
+	"github.com/khaledhikmat/family-meeting/mode/all"
+	"github.com/khaledhikmat/family-meeting/service/dispatch"
+	"github.com/khaledhikmat/family-meeting/service/ice"
+	"github.com/khaledhikmat/family-meeting/service/lgr"
+	"github.com/khaledhikmat/family-meeting/service/signaling"
+	"github.com/khaledhikmat/family-meeting/service/storage"
+	"github.com/khaledhikmat/family-meeting/utils"
+)
+
+const (
+	playRequestor = "play"
+	// Used when the Ogg granule positions do not tell the page duration
+	defaultOpusDuration = 20 * time.Millisecond
+	opusClockRate       = 48000
+	maxFrameDuration    = time.Second
+)
+
+// Video codecs by IVF FourCC
+var ivfCodecs = map[string]string{
+	"VP80": webrtc.MimeTypeVP8,
+	"VP90": webrtc.MimeTypeVP9,
+	"AV01": webrtc.MimeTypeAV1,
+}
+
+type options struct {
+	file  string
+	ogg   string
+	loop  bool
+	serve bool
+}
+
+// Processor publishes media files (IVF video and/or Ogg Opus audio) as a broadcaster would from a
+// browser: it creates a broadcast request with an offer and streams the files once answered.
+// It is used to broadcast pre-recorded content and to test the broadcast pipeline end to end.
+// i.e. `play --file movie.ivf --ogg audio.ogg [--loop] [--serve]`
+func Processor(canxCtx context.Context,
+	store signaling.SignalingStore,
+	dispatcher dispatch.Dispatcher,
+	sink storage.RecordingSink,
+	errorStream chan error) error {
+
+	lgr.Logger.Info("play proc started")
+
+	opts, err := parseOptions(os.Args[2:])
+	if err != nil {
+		return err
+	}
+
+	// Nobody else can answer the broadcast request of the memory store
+	if !opts.serve && signaling.IsMemory(store) {
+		return fmt.Errorf("play requires --serve with the memory signaling store")
+	}
+
+	// Get a child context so that the served processors stop when playing ends
+	playCanxCtx, playCanxFn := context.WithCancel(canxCtx)
+	defer playCanxFn()
+
+	// Run the monitor and broadcast processors in this process i.e. to use the memory store
+	if opts.serve {
+		go func() {
+			err := all.Processor(playCanxCtx, store, dispatcher, sink, errorStream)
+			if err != nil {
+				errorStream <- fmt.Errorf("play proc serving error: %v", err)
+			}
+			playCanxFn()
+		}()
+	}
+
+	err = play(playCanxCtx, store, errorStream, opts)
+	lgr.Logger.Info("play proc completed")
+	return err
+}
+
+func parseOptions(args []string) (options, error) {
+	opts := options{}
+	flags := flag.NewFlagSet("play", flag.ContinueOnError)
+	flags.StringVar(&opts.file, "file", "", "IVF video file (VP8, VP9 or AV1). It is not available over HLS which requires H264")
+	flags.StringVar(&opts.ogg, "ogg", "", "Ogg Opus audio file")
+	flags.BoolVar(&opts.loop, "loop", false, "Replay the files until stopped")
+	flags.BoolVar(&opts.serve, "serve", false, "Also run the monitor and broadcast processors in this process")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return opts, err
+	}
+
+	if opts.file == "" && opts.ogg == "" {
+		return opts, fmt.Errorf("play requires --file and/or --ogg")
+	}
+
+	return opts, nil
+}
+
+func play(canxCtx context.Context,
+	store signaling.SignalingStore,
+	errorStream chan error,
+	opts options) error {
+	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{
+		ICEServers: ice.Servers(""),
+	})
+	if err != nil {
+		return fmt.Errorf("play NewPeerConnection error: %v", err)
+	}
+	defer func() {
+		if cErr := peerConnection.Close(); cErr != nil {
+			errorStream <- fmt.Errorf("play cannot close peerConnection: %v", cErr)
+		}
+	}()
+
+	// Each file is streamed by its own player once connected
+	players := []func(ctx context.Context) error{}
+
+	if opts.file != "" {
+		player, err := newVideoPlayer(peerConnection, opts.file, opts.loop)
+		if err != nil {
+			return err
+		}
+		players = append(players, player)
+	}
+
+	if opts.ogg != "" {
+		player, err := newAudioPlayer(peerConnection, opts.ogg, opts.loop)
+		if err != nil {
+			return err
+		}
+		players = append(players, player)
+	}
+
+	requestCanxCtx, requestCanxFn := context.WithCancel(canxCtx)
+	defer requestCanxFn()
+
+	connectedCtx, connectedFn := context.WithCancel(requestCanxCtx)
+	defer connectedFn()
+	peerConnection.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		lgr.Logger.Info("play ICE connection state changed",
+			slog.String("state", state.String()),
+		)
+		if state == webrtc.ICEConnectionStateConnected {
+			connectedFn()
+		}
+	})
+
+	// The offer includes all candidates
+	offer, err := peerConnection.CreateOffer(nil)
+	if err != nil {
+		return fmt.Errorf("play CreateOffer error: %v", err)
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(peerConnection)
+	err = peerConnection.SetLocalDescription(offer)
+	if err != nil {
+		return fmt.Errorf("play SetLocalDescription error: %v", err)
+	}
+	<-gatherComplete
+
+	broadcastID, err := store.CreateRequest(canxCtx, utils.Request{
+		Requestor:   playRequestor,
+		Kind:        "broadcaster",
+		Offer:       utils.Encode(peerConnection.LocalDescription()),
+		Status:      utils.StatusRequested,
+		Transitions: []utils.Transition{signaling.NewTransition(utils.StatusRequested, "")},
+	})
+	if err != nil {
+		return fmt.Errorf("play store.CreateRequest error: %v", err)
+	}
+
+	lgr.Logger.Info("play created a broadcast",
+		slog.String("broadcast", broadcastID),
+	)
+
+	// End the broadcast when done playing
+	defer func() {
+		err := store.UpdateRequest(context.Background(), broadcastID, map[string]interface{}{
+			"abort": true,
+		})
+		if err != nil {
+			errorStream <- fmt.Errorf("play store.UpdateRequest error: %v", err)
+		}
+	}()
+
+	answer := signaling.WaitForAnswer(canxCtx, requestCanxCtx, errorStream, store, broadcastID)
+	if answer.SDP == "" {
+		return nil
+	}
+
+	err = peerConnection.SetRemoteDescription(answer)
+	if err != nil {
+		return fmt.Errorf("play SetRemoteDescription error: %v", err)
+	}
+
+	// Apply the candidates trickled by the core
+	go func() {
+		for candidate := range signaling.WatchCandidates(canxCtx, requestCanxCtx, errorStream, store, broadcastID, utils.AnswerCandidates) {
+			if err := peerConnection.AddICECandidate(candidate); err != nil {
+				errorStream <- fmt.Errorf("play AddICECandidate error: %v", err)
+			}
+		}
+	}()
+
+	// Stop playing if the broadcast is aborted
+	go func() {
+		if _, ok := <-store.WatchAbort(canxCtx, requestCanxCtx, errorStream, broadcastID); ok {
+			lgr.Logger.Info("play broadcast aborted",
+				slog.String("broadcast", broadcastID),
+			)
+			requestCanxFn()
+		}
+	}()
+
+	<-connectedCtx.Done()
+	if requestCanxCtx.Err() != nil {
+		return nil
+	}
+
+	lgr.Logger.Info("play streaming the files",
+		slog.String("broadcast", broadcastID),
+		slog.String("file", opts.file),
+		slog.String("ogg", opts.ogg),
+	)
+
+	doneStream := make(chan error, len(players))
+	for _, player := range players {
+		go func(player func(ctx context.Context) error) {
+			doneStream <- player(requestCanxCtx)
+		}(player)
+	}
+
+	// Playing is done when all files end
+	for range players {
+		err = errors.Join(err, <-doneStream)
+	}
+
+	return err
+}
+
+// newVideoPlayer adds a video track for the IVF file and returns its player
+func newVideoPlayer(peerConnection *webrtc.PeerConnection, path string, loop bool) (func(ctx context.Context) error, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	_, header, err := ivfreader.NewWith(file)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+
+	mimeType, ok := ivfCodecs[header.FourCC]
+	if !ok {
+		return nil, fmt.Errorf("unsupported IVF codec %s", header.FourCC)
+	}
+
+	lgr.Logger.Info("newVideoPlayer the video is only served over WebRTC since HLS requires H264",
+		slog.String("codec", mimeType),
+	)
+
+	track, err := addTrack(peerConnection, webrtc.RTPCodecCapability{MimeType: mimeType}, webrtc.RTPCodecTypeVideo)
+	if err != nil {
+		return nil, err
+	}
+
+	timebase := time.Second * time.Duration(header.TimebaseNumerator) / time.Duration(header.TimebaseDenominator)
+
+	return func(ctx context.Context) error {
+		return replay(ctx, path, loop, func(f io.Reader, offset time.Duration, pace func(at time.Duration) bool) (time.Duration, error) {
+			reader, _, err := ivfreader.NewWith(f)
+			if err != nil {
+				return 0, err
+			}
+
+			// The frame duration is estimated from the previous frame. Some writers (i.e. recordings)
+			// use RTP timestamps regardless of the timebase so the frame rate is used if it is off.
+			var at time.Duration
+			var last uint64
+			duration := timebase
+			for n := 0; ; n++ {
+				frame, frameHeader, err := reader.ParseNextFrame()
+				if err != nil {
+					return at + duration, ignoreEOF(err)
+				}
+
+				if n > 0 {
+					duration = time.Duration(frameHeader.Timestamp-last) * timebase
+					if duration <= 0 || duration > maxFrameDuration {
+						duration = timebase
+					}
+					at += duration
+				}
+				last = frameHeader.Timestamp
+
+				if !pace(offset + at) {
+					return at, nil
+				}
+
+				err = track.WriteSample(media.Sample{Data: frame, Duration: duration})
+				if err != nil && !errors.Is(err, io.ErrClosedPipe) {
+					return at, err
+				}
+			}
+		})
+	}, nil
+}
+
+// newAudioPlayer adds an audio track for the Ogg file and returns its player. The file must have
+// one Opus packet per page (i.e. recordings or `ffmpeg -page_duration 20000`).
+func newAudioPlayer(peerConnection *webrtc.PeerConnection, path string, loop bool) (func(ctx context.Context) error, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	_, _, err = oggreader.NewWith(file)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+
+	track, err := addTrack(peerConnection, webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, webrtc.RTPCodecTypeAudio)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context) error {
+		return replay(ctx, path, loop, func(f io.Reader, offset time.Duration, pace func(at time.Duration) bool) (time.Duration, error) {
+			reader, _, err := oggreader.NewWith(f)
+			if err != nil {
+				return 0, err
+			}
+
+			var at time.Duration
+			var granule uint64
+			for {
+				page, pageHeader, err := reader.ParseNextPage()
+				if err != nil {
+					return at, ignoreEOF(err)
+				}
+
+				duration := defaultOpusDuration
+				if pageHeader.GranulePosition > granule {
+					duration = time.Duration(pageHeader.GranulePosition-granule) * time.Second / opusClockRate
+					granule = pageHeader.GranulePosition
+				}
+
+				if !pace(offset + at) {
+					return at, nil
+				}
+				at += duration
+
+				err = track.WriteSample(media.Sample{Data: page, Duration: duration})
+				if err != nil && !errors.Is(err, io.ErrClosedPipe) {
+					return at, err
+				}
+			}
+		})
+	}, nil
+}
+
+func addTrack(peerConnection *webrtc.PeerConnection, capability webrtc.RTPCodecCapability, kind webrtc.RTPCodecType) (*webrtc.TrackLocalStaticSample, error) {
+	track, err := webrtc.NewTrackLocalStaticSample(capability, kind.String(), "play")
+	if err != nil {
+		return nil, fmt.Errorf("NewTrackLocalStaticSample error: %v", err)
+	}
+
+	sender, err := peerConnection.AddTrack(track)
+	if err != nil {
+		return nil, fmt.Errorf("AddTrack error: %v", err)
+	}
+
+	// Read incoming RTCP packets so the interceptors (i.e. NACK) work.
+	// Keyframe requests are ignored since the file decides the keyframes.
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			if _, _, err := sender.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	return track, nil
+}
+
+// replay reads the file (again and again if looping) pacing it in real time. The read function
+// returns the media duration it read and stops when pace returns false.
+func replay(ctx context.Context,
+	path string,
+	loop bool,
+	read func(f io.Reader, offset time.Duration, pace func(at time.Duration) bool) (time.Duration, error)) error {
+	start := time.Now()
+	pace := func(at time.Duration) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(time.Until(start.Add(at))):
+			return true
+		}
+	}
+
+	var offset time.Duration
+	for {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+
+		duration, err := read(f, offset, pace)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("playing %s: %v", path, err)
+		}
+
+		offset += duration
+		if !loop || ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+func ignoreEOF(err error) error {
+	// A truncated last frame or page is expected when the file is still being written
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil
+	}
+
+	return err
+}
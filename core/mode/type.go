@@ -3,12 +3,21 @@ package mode
 import (
 	"context"
 
-	"cloud.google.com/go/firestore"
-	firebase "firebase.google.com/go/v4"
+	"github.com/khaledhikmat/family-meeting/server"
+	"github.com/khaledhikmat/family-meeting/service/dispatch"
+	"github.com/khaledhikmat/family-meeting/service/signaling"
+	"github.com/khaledhikmat/family-meeting/service/storage"
 )
 
 // Signature of mode processors
 type Processor func(canxCtx context.Context,
-	app *firebase.App,
-	db *firestore.Client,
+	store signaling.SignalingStore,
+	dispatcher dispatch.Dispatcher,
+	sink storage.RecordingSink,
 	errorStream chan error) error
+
+// Signature of mode routers i.e. HTTP endpoints exposed by a mode
+type Router func(canxCtx context.Context,
+	store signaling.SignalingStore,
+	sink storage.RecordingSink,
+	errorStream chan error) []server.Route
@@ -4,17 +4,30 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/khaledhikmat/family-meeting/service/ice"
 	"github.com/khaledhikmat/family-meeting/service/lgr"
 	"github.com/mdobak/go-xerrors"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/metric"
 )
 
+// Comma-separated origins of the web clients allowed to fetch the ICE servers
+const allowedOriginsKey = "ICE_ALLOWED_ORIGINS"
+
 type ginWithContext func(canxCtx context.Context, errorStream chan error) error
 
+// Route is an HTTP endpoint contributed by a mode processor
+type Route struct {
+	Method  string
+	Path    string
+	Handler gin.HandlerFunc
+}
+
 var (
 	meter = otel.Meter(fmt.Sprintf("family.meeting.%s.server", os.Getenv("APP_NAME")))
 
@@ -36,7 +49,7 @@ func init() {
 	}
 }
 
-func Run(canxCtx context.Context, errorStream chan error, port string) error {
+func Run(canxCtx context.Context, errorStream chan error, port string, routes []Route) error {
 	r := gin.Default()
 
 	r.GET("/ping", func(c *gin.Context) {
@@ -46,10 +59,45 @@ func Run(canxCtx context.Context, errorStream chan error, port string) error {
 		})
 	})
 
+	// Web clients fetch the ICE servers so they match the core
+	r.GET("/ice-servers", iceServersHandler(canxCtx))
+
+	for _, route := range routes {
+		r.Handle(route.Method, route.Path, route.Handler)
+	}
+
 	fn := getRunWithCanxFn(r, ":"+port)
 	return fn(canxCtx, errorStream)
 }
 
+// iceServersHandler returns the ICE servers to the web clients of ICE_ALLOWED_ORIGINS only
+// since they carry the TURN credentials. Requests are rejected if it is not set.
+func iceServersHandler(canxCtx context.Context) gin.HandlerFunc {
+	origins := map[string]bool{}
+	for _, origin := range strings.Split(os.Getenv(allowedOriginsKey), ",") {
+		origin = strings.TrimSpace(origin)
+		if origin != "" {
+			origins[strings.ToLower(origin)] = true
+		}
+	}
+
+	return func(c *gin.Context) {
+		invocationCounter.Add(canxCtx, 1)
+
+		origin := c.GetHeader("Origin")
+		if !origins[strings.ToLower(origin)] {
+			c.String(http.StatusForbidden, "origin not allowed")
+			return
+		}
+
+		c.Header("Access-Control-Allow-Origin", origin)
+		c.Header("Vary", "Origin")
+		c.JSON(http.StatusOK, gin.H{
+			"iceServers": ice.Servers(""),
+		})
+	}
+}
+
 func getRunWithCanxFn(r *gin.Engine, port string) ginWithContext {
 	return func(canxCtx context.Context, errorStream chan error) error {
 		go func() {
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestICEServersHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name    string
+		allowed string
+		origin  string
+		status  int
+	}{
+		{
+			name:    "allowed origin",
+			allowed: "https://family.web.app, http://localhost:5173",
+			origin:  "http://localhost:5173",
+			status:  http.StatusOK,
+		},
+		{
+			name:    "origin case",
+			allowed: "https://Family.web.app",
+			origin:  "https://family.web.app",
+			status:  http.StatusOK,
+		},
+		{
+			name:    "other origin",
+			allowed: "https://family.web.app",
+			origin:  "https://evil.example.com",
+			status:  http.StatusForbidden,
+		},
+		{
+			name:    "no origin",
+			allowed: "https://family.web.app",
+			status:  http.StatusForbidden,
+		},
+		{
+			name:   "no allowed origins",
+			origin: "https://family.web.app",
+			status: http.StatusForbidden,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv(allowedOriginsKey, test.allowed)
+
+			r := gin.New()
+			r.GET("/ice-servers", iceServersHandler(context.Background()))
+
+			req := httptest.NewRequest(http.MethodGet, "/ice-servers?user=admin", nil)
+			if test.origin != "" {
+				req.Header.Set("Origin", test.origin)
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != test.status {
+				t.Fatalf("got status %d, want %d", w.Code, test.status)
+			}
+
+			allowOrigin := w.Header().Get("Access-Control-Allow-Origin")
+			if (test.status == http.StatusOK && allowOrigin != test.origin) || (test.status != http.StatusOK && allowOrigin != "") {
+				t.Fatalf("got allowed origin %q for %q", allowOrigin, test.origin)
+			}
+		})
+	}
+}
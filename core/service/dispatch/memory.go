@@ -0,0 +1,85 @@
+package dispatch
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+const (
+	memoryQueueSize        = 100
+	defaultRedeliveryDelay = 1 * time.Second
+)
+
+type memoryDispatcher struct {
+	queue chan *Message
+	// Nacked messages are queued again after the delay
+	redeliveryDelay time.Duration
+}
+
+// NewMemory creates an in-process channel-based dispatcher. Messages are only
+// visible to the process that created the dispatcher.
+func NewMemory(_ context.Context) (Dispatcher, error) {
+	return &memoryDispatcher{
+		queue:           make(chan *Message, memoryQueueSize),
+		redeliveryDelay: defaultRedeliveryDelay,
+	}, nil
+}
+
+func (d *memoryDispatcher) Publish(canxCtx context.Context, msg *Message) (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	published := &Message{
+		ID:         hex.EncodeToString(b),
+		Data:       msg.Data,
+		Attributes: withTraceContext(canxCtx, msg.Attributes),
+	}
+
+	select {
+	case <-canxCtx.Done():
+		return "", canxCtx.Err()
+	case d.queue <- published:
+		return published.ID, nil
+	}
+}
+
+func (d *memoryDispatcher) Receive(canxCtx context.Context, handler Handler) error {
+	for {
+		select {
+		case <-canxCtx.Done():
+			return nil
+		case msg := <-d.queue:
+			msg.DeliveryAttempt++
+			delivered := &Message{
+				ID:              msg.ID,
+				Data:            msg.Data,
+				Attributes:      msg.Attributes,
+				DeliveryAttempt: msg.DeliveryAttempt,
+				ackFn:           func() {},
+				nackFn: func() {
+					// Redeliver after a delay so a busy processor is not spinning on the same message
+					go func() {
+						select {
+						case <-canxCtx.Done():
+						case <-time.After(d.redeliveryDelay):
+							select {
+							case <-canxCtx.Done():
+							case d.queue <- msg:
+							}
+						}
+					}()
+				},
+			}
+			handler(canxCtx, delivered)
+		}
+	}
+}
+
+func (d *memoryDispatcher) Close() error {
+	return nil
+}
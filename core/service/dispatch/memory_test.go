@@ -0,0 +1,146 @@
+package dispatch
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// The redelivery delay is shortened so the tests do not wait
+const testRedeliveryDelay = 50 * time.Millisecond
+
+func newTestDispatcher(t *testing.T, canxCtx context.Context) Dispatcher {
+	t.Helper()
+
+	dispatcher, err := NewMemory(canxCtx)
+	if err != nil {
+		t.Fatalf("NewMemory error: %v", err)
+	}
+	dispatcher.(*memoryDispatcher).redeliveryDelay = testRedeliveryDelay
+
+	return dispatcher
+}
+
+func TestMemoryRedelivery(t *testing.T) {
+	tests := []struct {
+		name string
+		// Nack the first deliveries then Ack
+		nacks    int
+		attempts []int
+	}{
+		{
+			name:     "ack",
+			attempts: []int{1},
+		},
+		{
+			name:     "nack",
+			nacks:    1,
+			attempts: []int{1, 2},
+		},
+		{
+			name:     "nack twice",
+			nacks:    2,
+			attempts: []int{1, 2, 3},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			canxCtx, canxFn := context.WithCancel(context.Background())
+			defer canxFn()
+
+			dispatcher := newTestDispatcher(t, canxCtx)
+			defer dispatcher.Close()
+
+			id, err := dispatcher.Publish(canxCtx, &Message{Data: []byte("request")})
+			if err != nil {
+				t.Fatalf("Publish error: %v", err)
+			}
+
+			deliveries := make(chan *Message, len(test.attempts)+1)
+			go func() {
+				_ = dispatcher.Receive(canxCtx, func(_ context.Context, msg *Message) {
+					deliveries <- msg
+					if msg.DeliveryAttempt <= test.nacks {
+						msg.Nack()
+						return
+					}
+					msg.Ack()
+				})
+			}()
+
+			for _, attempt := range test.attempts {
+				select {
+				case <-time.After(testRedeliveryDelay * 2):
+					t.Fatalf("delivery attempt %d not received", attempt)
+				case msg := <-deliveries:
+					if msg.ID != id || string(msg.Data) != "request" || msg.DeliveryAttempt != attempt {
+						t.Fatalf("got message %s %q attempt %d, want %s attempt %d", msg.ID, msg.Data, msg.DeliveryAttempt, id, attempt)
+					}
+				}
+			}
+
+			// An acknowledged message is not delivered again
+			select {
+			case msg := <-deliveries:
+				t.Fatalf("got delivery attempt %d after the ack", msg.DeliveryAttempt)
+			case <-time.After(testRedeliveryDelay * 2):
+			}
+		})
+	}
+}
+
+func TestMemoryNackAfterCancel(t *testing.T) {
+	canxCtx, canxFn := context.WithCancel(context.Background())
+	defer canxFn()
+
+	dispatcher := newTestDispatcher(t, canxCtx)
+	defer dispatcher.Close()
+
+	_, err := dispatcher.Publish(canxCtx, &Message{Data: []byte("request")})
+	if err != nil {
+		t.Fatalf("Publish error: %v", err)
+	}
+
+	receiveCtx, receiveCanxFn := context.WithCancel(canxCtx)
+	done := make(chan error)
+	go func() {
+		done <- dispatcher.Receive(receiveCtx, func(_ context.Context, msg *Message) {
+			receiveCanxFn()
+			msg.Nack()
+		})
+	}()
+
+	select {
+	case <-time.After(testRedeliveryDelay * 2):
+		t.Fatalf("Receive did not return on cancel")
+	case err = <-done:
+		if err != nil {
+			t.Fatalf("Receive error: %v", err)
+		}
+	}
+
+	// The nacked message is not redelivered once the receiver is cancelled
+	time.Sleep(testRedeliveryDelay * 2)
+	_, err = dispatcher.Publish(canxCtx, &Message{Data: []byte("next")})
+	if err != nil {
+		t.Fatalf("Publish error: %v", err)
+	}
+
+	received := make(chan *Message, 2)
+	go func() {
+		_ = dispatcher.Receive(canxCtx, func(_ context.Context, msg *Message) {
+			received <- msg
+			msg.Ack()
+		})
+	}()
+
+	select {
+	case <-time.After(testRedeliveryDelay):
+		t.Fatalf("message not received")
+	case msg := <-received:
+		if string(msg.Data) != "next" {
+			t.Fatalf("got %q, want the next message", msg.Data)
+		}
+	}
+}
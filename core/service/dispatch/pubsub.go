@@ -0,0 +1,100 @@
+package dispatch
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"cloud.google.com/go/pubsub"
+)
+
+const (
+	defaultProjectID = "family-meeting-aa853"
+	broadcastsTopic  = "broadcasts"
+	broadcastsSub    = "broadcasts-sub"
+)
+
+type pubsubDispatcher struct {
+	client *pubsub.Client
+	topic  *pubsub.Topic
+}
+
+// NewPubSub creates a Google Pub/Sub dispatcher. The project is taken from
+// GOOGLE_CLOUD_PROJECT and the `broadcasts` topic must exist.
+func NewPubSub(canxCtx context.Context) (Dispatcher, error) {
+	projectID := defaultProjectID
+	if os.Getenv("GOOGLE_CLOUD_PROJECT") != "" {
+		projectID = os.Getenv("GOOGLE_CLOUD_PROJECT")
+	}
+
+	client, err := pubsub.NewClient(canxCtx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("creating pubsub client: %v", err)
+	}
+
+	t := client.Topic(broadcastsTopic)
+
+	ok, err := t.Exists(canxCtx)
+	if err != nil {
+		t.Stop()
+		client.Close()
+		return nil, fmt.Errorf("checking topic %s: %v", broadcastsTopic, err)
+	}
+
+	if !ok {
+		t.Stop()
+		client.Close()
+		return nil, fmt.Errorf("checking topic %s: topic does not exist", broadcastsTopic)
+	}
+
+	return &pubsubDispatcher{
+		client: client,
+		topic:  t,
+	}, nil
+}
+
+func (d *pubsubDispatcher) Publish(canxCtx context.Context, msg *Message) (string, error) {
+	result := d.topic.Publish(canxCtx, &pubsub.Message{
+		Data:       msg.Data,
+		Attributes: withTraceContext(canxCtx, msg.Attributes),
+	})
+	return result.Get(canxCtx)
+}
+
+func (d *pubsubDispatcher) Receive(canxCtx context.Context, handler Handler) error {
+	// Make sure topic subscription exists
+	sub := d.client.Subscription(broadcastsSub)
+
+	ok, err := sub.Exists(canxCtx)
+	if err != nil {
+		return fmt.Errorf("checking topic %s sub %s: %v", broadcastsTopic, broadcastsSub, err)
+	}
+
+	if !ok {
+		return fmt.Errorf("checking topic %s sub %s: topic sub does not exist", broadcastsTopic, broadcastsSub)
+	}
+
+	// Receive blocks until the context is cancelled
+	// There is more control: https://cloud.google.com/pubsub/docs/samples/pubsub-subscriber-concurrency-control?hl=en
+	return sub.Receive(canxCtx, func(ctx context.Context, m *pubsub.Message) {
+		msg := &Message{
+			ID:         m.ID,
+			Data:       m.Data,
+			Attributes: m.Attributes,
+			ackFn:      m.Ack,
+			nackFn:     m.Nack,
+		}
+
+		// Only set if the subscription has a dead letter policy
+		if m.DeliveryAttempt != nil {
+			msg.DeliveryAttempt = *m.DeliveryAttempt
+		}
+
+		handler(ctx, msg)
+	})
+}
+
+func (d *pubsubDispatcher) Close() error {
+	d.topic.Stop()
+	return d.client.Close()
+}
@@ -0,0 +1,69 @@
+package dispatch
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Message carries a broadcast ID from the monitor to the broadcast processor
+type Message struct {
+	ID         string
+	Data       []byte
+	Attributes map[string]string
+	// The number of times the message was delivered including this one. It is zero if the
+	// dispatcher does not tell i.e. a Pub/Sub subscription without a dead letter policy.
+	DeliveryAttempt int
+
+	ackFn  func()
+	nackFn func()
+}
+
+// Ack acknowledges the message so it is not delivered again
+func (m *Message) Ack() {
+	if m.ackFn != nil {
+		m.ackFn()
+	}
+}
+
+// Nack negatively acknowledges the message so it is redelivered (possibly to another instance)
+func (m *Message) Nack() {
+	if m.nackFn != nil {
+		m.nackFn()
+	}
+}
+
+// Context returns the context carrying the trace propagated in the message attributes so the
+// receiver continues the publisher trace
+func (m *Message) Context(canxCtx context.Context) context.Context {
+	return otel.GetTextMapPropagator().Extract(canxCtx, propagation.MapCarrier(m.Attributes))
+}
+
+// withTraceContext returns a copy of the attributes along with the trace of the publisher context
+// (i.e. `traceparent`) as configured by the global propagator
+func withTraceContext(canxCtx context.Context, attributes map[string]string) map[string]string {
+	carrier := propagation.MapCarrier{}
+	for key, value := range attributes {
+		carrier[key] = value
+	}
+
+	otel.GetTextMapPropagator().Inject(canxCtx, carrier)
+	return carrier
+}
+
+// Handler processes a received message. It must call Ack or Nack.
+type Handler func(canxCtx context.Context, msg *Message)
+
+// Dispatcher abstracts the queue used to dispatch broadcasts from monitor to broadcast
+type Dispatcher interface {
+	// Publish sends a message and returns its ID. The trace of the context is propagated
+	// in the message attributes.
+	Publish(canxCtx context.Context, msg *Message) (string, error)
+
+	// Receive blocks and delivers messages to the handler until the context is cancelled
+	Receive(canxCtx context.Context, handler Handler) error
+
+	// Close releases the dispatcher resources
+	Close() error
+}
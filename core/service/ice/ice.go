@@ -0,0 +1,137 @@
+package ice
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/khaledhikmat/family-meeting/service/lgr"
+)
+
+/*
+Reference:
+https://datatracker.ietf.org/doc/html/draft-uberti-behave-turn-rest-00
+*/
+const (
+	defaultStunURLs = "stun:stun.l.google.com:19302"
+	defaultTurnTTL  = 24 * time.Hour
+	defaultTurnUser = "family-meeting"
+
+	stunURLsKey       = "ICE_STUN_URLS"
+	turnURLsKey       = "ICE_TURN_URLS"
+	turnUsernameKey   = "ICE_TURN_USERNAME"
+	turnCredentialKey = "ICE_TURN_CREDENTIAL"
+	turnSecretKey     = "ICE_TURN_SECRET"
+	turnTTLKey        = "ICE_TURN_TTL"
+)
+
+type config struct {
+	StunURLs       []string
+	TurnURLs       []string
+	TurnUsername   string
+	TurnCredential string
+	// Shared secret with the TURN server to issue time-limited credentials (i.e. coturn `use-auth-secret`)
+	TurnSecret string
+	TurnTTL    time.Duration
+}
+
+var cfg config
+
+func init() {
+	// Setup ICE servers
+	cfg = config{
+		StunURLs:       splitList(defaultStunURLs),
+		TurnURLs:       splitList(os.Getenv(turnURLsKey)),
+		TurnUsername:   os.Getenv(turnUsernameKey),
+		TurnCredential: os.Getenv(turnCredentialKey),
+		TurnSecret:     os.Getenv(turnSecretKey),
+		TurnTTL:        defaultTurnTTL,
+	}
+
+	if os.Getenv(stunURLsKey) != "" {
+		cfg.StunURLs = splitList(os.Getenv(stunURLsKey))
+	}
+
+	if os.Getenv(turnTTLKey) != "" {
+		ttl, err := time.ParseDuration(os.Getenv(turnTTLKey))
+		if err != nil || ttl <= 0 {
+			lgr.Logger.Error(
+				"parsing ICE TURN TTL",
+				slog.String("ttl", os.Getenv(turnTTLKey)),
+				slog.Any("error", xerrors.New("invalid duration: "+os.Getenv(turnTTLKey))),
+			)
+		} else {
+			cfg.TurnTTL = ttl
+		}
+	}
+
+	if len(cfg.TurnURLs) > 0 && cfg.TurnSecret == "" && (cfg.TurnUsername == "" || cfg.TurnCredential == "") {
+		lgr.Logger.Error(
+			"setting up ICE TURN servers",
+			slog.Any("error", xerrors.New("TURN urls require either a secret or a username and credential")),
+		)
+	}
+}
+
+// Servers returns the configured STUN and TURN servers. When a TURN secret is configured,
+// the TURN credentials are issued for the user and expire after the configured TTL.
+func Servers(user string) []webrtc.ICEServer {
+	servers := []webrtc.ICEServer{}
+
+	if len(cfg.StunURLs) > 0 {
+		servers = append(servers, webrtc.ICEServer{
+			URLs: cfg.StunURLs,
+		})
+	}
+
+	if len(cfg.TurnURLs) == 0 {
+		return servers
+	}
+
+	username, credential := cfg.TurnUsername, cfg.TurnCredential
+	if cfg.TurnSecret != "" {
+		username, credential = turnRESTCredentials(cfg.TurnSecret, user, cfg.TurnTTL)
+	}
+
+	return append(servers, webrtc.ICEServer{
+		URLs:           cfg.TurnURLs,
+		Username:       username,
+		Credential:     credential,
+		CredentialType: webrtc.ICECredentialTypePassword,
+	})
+}
+
+// turnRESTCredentials issues time-limited TURN credentials i.e. the username is the
+// expiry timestamp and the user and the credential is the base64 HMAC-SHA1 of the username
+func turnRESTCredentials(secret string, user string, ttl time.Duration) (string, string) {
+	if user == "" {
+		user = defaultTurnUser
+	}
+
+	username := fmt.Sprintf("%d:%s", time.Now().Add(ttl).Unix(), user)
+
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+
+	return username, base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func splitList(in string) []string {
+	items := []string{}
+	for _, item := range strings.Split(in, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
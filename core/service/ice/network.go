@@ -0,0 +1,115 @@
+package ice
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	tcpMuxReadBufferSize = 8
+
+	udpMuxPortKey = "ICE_UDP_MUX_PORT"
+	tcpMuxPortKey = "ICE_TCP_MUX_PORT"
+	portMinKey    = "ICE_PORT_MIN"
+	portMaxKey    = "ICE_PORT_MAX"
+	nat1To1IPsKey = "ICE_NAT_1TO1_IPS"
+)
+
+var (
+	settingEngineOnce sync.Once
+	settingEngine     webrtc.SettingEngine
+	settingEngineErr  error
+)
+
+// SettingEngine returns the setting engine shared by all peer connections. If configured,
+// all ICE traffic is multiplexed over a single UDP (and optionally TCP) port so the core
+// can be exposed behind a regular load balancer. The muxes are created once per process.
+func SettingEngine() (webrtc.SettingEngine, error) {
+	settingEngineOnce.Do(func() {
+		settingEngine, settingEngineErr = newSettingEngine()
+	})
+
+	return settingEngine, settingEngineErr
+}
+
+func newSettingEngine() (webrtc.SettingEngine, error) {
+	se := webrtc.SettingEngine{}
+
+	udpMuxPort, err := envPort(udpMuxPortKey)
+	if err != nil {
+		return se, err
+	}
+
+	tcpMuxPort, err := envPort(tcpMuxPortKey)
+	if err != nil {
+		return se, err
+	}
+
+	portMin, err := envPort(portMinKey)
+	if err != nil {
+		return se, err
+	}
+
+	portMax, err := envPort(portMaxKey)
+	if err != nil {
+		return se, err
+	}
+
+	if udpMuxPort != 0 {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: int(udpMuxPort)})
+		if err != nil {
+			return se, fmt.Errorf("listening on ICE UDP mux port %d: %v", udpMuxPort, err)
+		}
+
+		se.SetICEUDPMux(webrtc.NewICEUDPMux(nil, conn))
+	}
+
+	if tcpMuxPort != 0 {
+		listener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: int(tcpMuxPort)})
+		if err != nil {
+			return se, fmt.Errorf("listening on ICE TCP mux port %d: %v", tcpMuxPort, err)
+		}
+
+		se.SetICETCPMux(webrtc.NewICETCPMux(nil, listener, tcpMuxReadBufferSize))
+		se.SetNetworkTypes([]webrtc.NetworkType{
+			webrtc.NetworkTypeUDP4,
+			webrtc.NetworkTypeUDP6,
+			webrtc.NetworkTypeTCP4,
+			webrtc.NetworkTypeTCP6,
+		})
+	}
+
+	// The port range only applies to the connections not served by the UDP mux
+	if portMin != 0 || portMax != 0 {
+		err = se.SetEphemeralUDPPortRange(portMin, portMax)
+		if err != nil {
+			return se, fmt.Errorf("setting ICE port range %d-%d: %v", portMin, portMax, err)
+		}
+	}
+
+	// Advertise the public IP(s) instead of the private host IP(s) i.e. behind a cloud NAT
+	ips := splitList(os.Getenv(nat1To1IPsKey))
+	if len(ips) > 0 {
+		se.SetNAT1To1IPs(ips, webrtc.ICECandidateTypeHost)
+	}
+
+	return se, nil
+}
+
+func envPort(key string) (uint16, error) {
+	if os.Getenv(key) == "" {
+		return 0, nil
+	}
+
+	port, err := strconv.ParseUint(os.Getenv(key), 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("parsing %s: %v", key, err)
+	}
+
+	return uint16(port), nil
+}
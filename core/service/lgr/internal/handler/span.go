@@ -30,6 +30,16 @@ func (h *Span) Handle(ctx context.Context, record slog.Record) error {
 	return h.Handler.Handle(ctx, record)
 }
 
+// WithAttrs keeps the span handler on top of the derived handler (i.e. `Logger.With`)
+func (h *Span) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return NewSpan(h.Handler.WithAttrs(attrs))
+}
+
+// WithGroup keeps the span handler on top of the derived handler
+func (h *Span) WithGroup(name string) slog.Handler {
+	return NewSpan(h.Handler.WithGroup(name))
+}
+
 func NewSpan(handler slog.Handler) *Span {
 	h := &Span{
 		Handler: handler,
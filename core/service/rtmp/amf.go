@@ -0,0 +1,215 @@
+package rtmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// AMF0 markers
+const (
+	amfNumber      = 0x00
+	amfBoolean     = 0x01
+	amfString      = 0x02
+	amfObject      = 0x03
+	amfNull        = 0x05
+	amfUndefined   = 0x06
+	amfECMAArray   = 0x08
+	amfObjectEnd   = 0x09
+	amfStrictArray = 0x0a
+	amfDate        = 0x0b
+	amfLongString  = 0x0c
+)
+
+// amfMaxDepth limits the nesting of objects and arrays. Commands are decoded before the publisher
+// is authenticated so a deeply nested message must not exhaust the stack.
+const amfMaxDepth = 32
+
+// decodeAMF decodes all the AMF0 values of a command or data message. Numbers are float64,
+// objects and ECMA arrays are maps and strict arrays are slices.
+func decodeAMF(payload []byte) ([]interface{}, error) {
+	r := bytes.NewReader(payload)
+	values := []interface{}{}
+	for r.Len() > 0 {
+		value, err := decodeAMFValue(r, 0)
+		if err != nil {
+			return values, err
+		}
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+func decodeAMFValue(r *bytes.Reader, depth int) (interface{}, error) {
+	if depth > amfMaxDepth {
+		return nil, fmt.Errorf("amf0 values nested deeper than %d", amfMaxDepth)
+	}
+
+	marker, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch marker {
+	case amfNumber:
+		var n uint64
+		err = binary.Read(r, binary.BigEndian, &n)
+		return math.Float64frombits(n), err
+	case amfBoolean:
+		b, err := r.ReadByte()
+		return b != 0, err
+	case amfString:
+		return decodeAMFString(r, 2)
+	case amfLongString:
+		return decodeAMFString(r, 4)
+	case amfObject:
+		return decodeAMFObject(r, depth)
+	case amfECMAArray:
+		// The count is only a hint. The entries end like an object.
+		_, err = r.Seek(4, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		return decodeAMFObject(r, depth)
+	case amfStrictArray:
+		var count uint32
+		err = binary.Read(r, binary.BigEndian, &count)
+		if err != nil {
+			return nil, err
+		}
+
+		values := []interface{}{}
+		for i := uint32(0); i < count; i++ {
+			value, err := decodeAMFValue(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	case amfDate:
+		// Milliseconds and time zone
+		var ms uint64
+		err = binary.Read(r, binary.BigEndian, &ms)
+		if err != nil {
+			return nil, err
+		}
+		_, err = r.Seek(2, io.SeekCurrent)
+		return math.Float64frombits(ms), err
+	case amfNull, amfUndefined:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported amf0 marker 0x%02x", marker)
+	}
+}
+
+func decodeAMFString(r *bytes.Reader, lengthSize int) (string, error) {
+	header := make([]byte, lengthSize)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return "", err
+	}
+
+	length := 0
+	for _, b := range header {
+		length = length<<8 | int(b)
+	}
+
+	// Do not allocate a (long) string the message cannot hold
+	if length > r.Len() {
+		return "", io.ErrUnexpectedEOF
+	}
+
+	s := make([]byte, length)
+	_, err = io.ReadFull(r, s)
+	return string(s), err
+}
+
+func decodeAMFObject(r *bytes.Reader, depth int) (map[string]interface{}, error) {
+	object := map[string]interface{}{}
+	for {
+		key, err := decodeAMFString(r, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		if key == "" {
+			marker, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+
+			if marker == amfObjectEnd {
+				return object, nil
+			}
+
+			// Not the end marker so it is the value of an empty key
+			err = r.UnreadByte()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		value, err := decodeAMFValue(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		object[key] = value
+	}
+}
+
+// encodeAMF encodes the values. Only the types sent by the server are supported.
+func encodeAMF(values ...interface{}) []byte {
+	b := &bytes.Buffer{}
+	for _, value := range values {
+		encodeAMFValue(b, value)
+	}
+
+	return b.Bytes()
+}
+
+func encodeAMFValue(b *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case float64:
+		b.WriteByte(amfNumber)
+		_ = binary.Write(b, binary.BigEndian, math.Float64bits(v))
+	case int:
+		encodeAMFValue(b, float64(v))
+	case bool:
+		b.WriteByte(amfBoolean)
+		if v {
+			b.WriteByte(1)
+		} else {
+			b.WriteByte(0)
+		}
+	case string:
+		b.WriteByte(amfString)
+		encodeAMFKey(b, v)
+	case map[string]interface{}:
+		b.WriteByte(amfObject)
+
+		// Sorted for a stable encoding
+		keys := []string{}
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			encodeAMFKey(b, key)
+			encodeAMFValue(b, v[key])
+		}
+		b.Write([]byte{0x00, 0x00, amfObjectEnd})
+	default:
+		b.WriteByte(amfNull)
+	}
+}
+
+func encodeAMFKey(b *bytes.Buffer, key string) {
+	_ = binary.Write(b, binary.BigEndian, uint16(len(key)))
+	b.WriteString(key)
+}
@@ -0,0 +1,205 @@
+package rtmp
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// nestedObjects returns objects nested depth times under the empty key
+func nestedObjects(depth int) []byte {
+	payload := []byte{amfObject}
+	for i := 1; i < depth; i++ {
+		payload = append(payload, 0x00, 0x00, amfObject)
+	}
+
+	for i := 0; i < depth; i++ {
+		payload = append(payload, 0x00, 0x00, amfObjectEnd)
+	}
+
+	return payload
+}
+
+// nestedArrays returns strict arrays of one element nested depth times
+func nestedArrays(depth int) []byte {
+	payload := bytes.Repeat([]byte{amfStrictArray, 0x00, 0x00, 0x00, 0x01}, depth)
+	return append(payload, amfNull)
+}
+
+func TestDecodeAMF(t *testing.T) {
+	connect := encodeAMF("connect", 1, map[string]interface{}{
+		"app":          "live",
+		"tcUrl":        "rtmp://localhost/live",
+		"fpad":         false,
+		"capabilities": 15,
+	})
+
+	tests := []struct {
+		name    string
+		payload []byte
+		values  []interface{}
+		fails   bool
+	}{
+		{
+			name:    "command",
+			payload: connect,
+			values: []interface{}{"connect", float64(1), map[string]interface{}{
+				"app":          "live",
+				"tcUrl":        "rtmp://localhost/live",
+				"fpad":         false,
+				"capabilities": float64(15),
+			}},
+		},
+		{
+			name:    "null and undefined",
+			payload: []byte{amfNull, amfUndefined},
+			values:  []interface{}{nil, nil},
+		},
+		{
+			name: "ecma array",
+			payload: []byte{
+				amfECMAArray, 0x00, 0x00, 0x00, 0x01,
+				0x00, 0x05, 'w', 'i', 'd', 't', 'h', amfNumber, 0x40, 0x94, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, amfObjectEnd,
+			},
+			values: []interface{}{map[string]interface{}{"width": float64(1280)}},
+		},
+		{
+			name: "strict array",
+			payload: []byte{
+				amfStrictArray, 0x00, 0x00, 0x00, 0x02,
+				amfBoolean, 0x01,
+				amfString, 0x00, 0x01, 'a',
+			},
+			values: []interface{}{[]interface{}{true, "a"}},
+		},
+		{
+			name:    "long string",
+			payload: []byte{amfLongString, 0x00, 0x00, 0x00, 0x02, 'o', 'k'},
+			values:  []interface{}{"ok"},
+		},
+		{
+			name:    "empty",
+			payload: []byte{},
+			values:  []interface{}{},
+		},
+		{
+			name:    "truncated command keeps the decoded values",
+			payload: connect[:len(connect)-5],
+			values:  []interface{}{"connect", float64(1)},
+			fails:   true,
+		},
+		{
+			name:    "truncated number",
+			payload: []byte{amfNumber, 0x40, 0x94},
+			values:  []interface{}{},
+			fails:   true,
+		},
+		{
+			name:    "truncated boolean",
+			payload: []byte{amfBoolean},
+			values:  []interface{}{},
+			fails:   true,
+		},
+		{
+			name:    "string longer than the message",
+			payload: []byte{amfString, 0x00, 0x10, 'a'},
+			values:  []interface{}{},
+			fails:   true,
+		},
+		{
+			name:    "long string longer than the message",
+			payload: []byte{amfLongString, 0xff, 0xff, 0xff, 0xff, 'a'},
+			values:  []interface{}{},
+			fails:   true,
+		},
+		{
+			name:    "object without an end",
+			payload: []byte{amfObject, 0x00, 0x01, 'a', amfNull},
+			values:  []interface{}{},
+			fails:   true,
+		},
+		{
+			name:    "strict array shorter than its count",
+			payload: []byte{amfStrictArray, 0xff, 0xff, 0xff, 0xff, amfNull},
+			values:  []interface{}{},
+			fails:   true,
+		},
+		{
+			name:    "truncated ecma array",
+			payload: []byte{amfECMAArray, 0x00, 0x00},
+			values:  []interface{}{},
+			fails:   true,
+		},
+		{
+			name:    "unsupported marker",
+			payload: []byte{amfString, 0x00, 0x00, 0x11},
+			values:  []interface{}{""},
+			fails:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values, err := decodeAMF(test.payload)
+			if (err != nil) != test.fails {
+				t.Fatalf("got error %v, want failure %v", err, test.fails)
+			}
+
+			if !reflect.DeepEqual(values, test.values) {
+				t.Fatalf("got %#v, want %#v", values, test.values)
+			}
+		})
+	}
+}
+
+func TestDecodeAMFDepth(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		fails   bool
+	}{
+		{
+			name:    "objects at the limit",
+			payload: nestedObjects(amfMaxDepth + 1),
+		},
+		{
+			name:    "objects past the limit",
+			payload: nestedObjects(amfMaxDepth + 2),
+			fails:   true,
+		},
+		{
+			name:    "arrays past the limit",
+			payload: nestedArrays(amfMaxDepth + 2),
+			fails:   true,
+		},
+		{
+			name: "ecma arrays past the limit",
+			payload: append(
+				bytes.Repeat([]byte{amfECMAArray, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 'a'}, amfMaxDepth+2),
+				amfNull,
+			),
+			fails: true,
+		},
+		{
+			// Would overflow the stack without the limit
+			name:    "truncated deep nesting",
+			payload: nestedObjects(1 << 20)[:3<<20],
+			fails:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := decodeAMF(test.payload)
+			if (err != nil) != test.fails {
+				t.Fatalf("got error %v, want failure %v", err, test.fails)
+			}
+
+			if test.fails && !strings.Contains(err.Error(), "nested deeper") {
+				t.Fatalf("got error %v, want the nesting error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,600 @@
+package rtmp
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+/*
+Reference:
+https://rtmp.veriskope.com/docs/spec/
+Only what is needed to receive a publish from an encoder (i.e. OBS or ffmpeg) is implemented:
+the simple handshake, the chunk stream, the publish command sequence and H264 (AVC) video.
+Audio and metadata are read and dropped.
+*/
+const (
+	version           = 3
+	handshakeSize     = 1536
+	defaultChunkSize  = 128
+	windowAckSize     = 2500000
+	readTimeout       = 30 * time.Second
+	controlChunkID    = 2
+	commandChunkID    = 3
+	publishStreamID   = 1
+	maxMessageLength  = 16 * 1024 * 1024
+	extendedTimestamp = 0xffffff
+)
+
+// Limits on what a publisher can make the server hold before the publish is accepted
+const (
+	// Commands are small. Media messages are accepted up to maxMessageLength once publishing.
+	maxCommandLength = 64 * 1024
+	// Encoders use a handful of chunk streams
+	maxChunkStreams = 16
+)
+
+// Message types
+const (
+	typeSetChunkSize     = 1
+	typeAbort            = 2
+	typeAcknowledgement  = 3
+	typeUserControl      = 4
+	typeWindowAckSize    = 5
+	typeSetPeerBandwidth = 6
+	typeAudio            = 8
+	typeVideo            = 9
+	typeDataAMF0         = 18
+	typeCommandAMF0      = 20
+)
+
+// FLV video tag fields
+const (
+	codecAVC          = 7
+	frameTypeKey      = 1
+	avcSequenceHeader = 0
+	avcNALU           = 1
+)
+
+// Video is a video message converted to Annex-B. A sequence header only carries the parameter sets.
+type Video struct {
+	// Decoding time in milliseconds
+	Time uint32
+	// Offset of the presentation time from the decoding time in milliseconds. It is not zero
+	// when the publisher sends B-frames.
+	CompositionTime int32
+	Keyframe        bool
+	SPS             []byte
+	PPS             []byte
+	AU              []byte
+}
+
+// PresentationTime returns the presentation time in milliseconds i.e. the decoding time plus the
+// composition time
+func (v Video) PresentationTime() uint32 {
+	return uint32(int64(v.Time) + int64(v.CompositionTime))
+}
+
+// Conn is the server side of an RTMP connection from a publisher
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	chunkSize uint32
+	streams   map[uint32]*chunkStream
+	received  uint32
+	acked     uint32
+
+	// Largest message accepted. It is raised once the publish is accepted.
+	messageLimit uint32
+
+	// Size of the NAL unit lengths from the AVC sequence header
+	nalLengthSize int
+}
+
+type chunkStream struct {
+	timestamp uint32
+	delta     uint32
+	length    uint32
+	typeID    uint8
+	streamID  uint32
+	extended  bool
+	payload   []byte
+}
+
+type message struct {
+	typeID    uint8
+	streamID  uint32
+	timestamp uint32
+	payload   []byte
+}
+
+// NewConn wraps an accepted connection
+func NewConn(conn net.Conn) *Conn {
+	c := &Conn{
+		conn:          conn,
+		chunkSize:     defaultChunkSize,
+		streams:       map[uint32]*chunkStream{},
+		nalLengthSize: 4,
+		messageLimit:  maxCommandLength,
+	}
+	c.reader = bufio.NewReader(&countingReader{conn: conn, count: &c.received})
+	return c
+}
+
+// Handshake runs the simple (unsigned) handshake which encoders accept when publishing
+func (c *Conn) Handshake() error {
+	err := c.conn.SetDeadline(time.Now().Add(readTimeout))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = c.conn.SetDeadline(time.Time{})
+	}()
+
+	c0c1 := make([]byte, 1+handshakeSize)
+	_, err = io.ReadFull(c.reader, c0c1)
+	if err != nil {
+		return fmt.Errorf("reading c0/c1: %v", err)
+	}
+
+	if c0c1[0] != version {
+		return fmt.Errorf("unsupported rtmp version %d", c0c1[0])
+	}
+
+	s0s1s2 := make([]byte, 1+2*handshakeSize)
+	s0s1s2[0] = version
+	_, err = rand.Read(s0s1s2[9 : 1+handshakeSize])
+	if err != nil {
+		return err
+	}
+	// S2 echoes C1
+	copy(s0s1s2[1+handshakeSize:], c0c1[1:])
+
+	_, err = c.conn.Write(s0s1s2)
+	if err != nil {
+		return fmt.Errorf("writing s0/s1/s2: %v", err)
+	}
+
+	_, err = io.ReadFull(c.reader, make([]byte, handshakeSize))
+	if err != nil {
+		return fmt.Errorf("reading c2: %v", err)
+	}
+
+	return nil
+}
+
+// WaitForPublish answers the connect and createStream commands and returns the stream key
+// once the publisher sends the publish command. It must be accepted or rejected.
+func (c *Conn) WaitForPublish() (string, error) {
+	for {
+		msg, err := c.readMessage()
+		if err != nil {
+			return "", err
+		}
+
+		if msg.typeID != typeCommandAMF0 {
+			continue
+		}
+
+		values, err := decodeAMF(msg.payload)
+		if err != nil || len(values) < 2 {
+			return "", fmt.Errorf("invalid command: %v", err)
+		}
+
+		name, _ := values[0].(string)
+		txn, _ := values[1].(float64)
+		switch name {
+		case "connect":
+			err = c.writeControl(typeWindowAckSize, binary.BigEndian.AppendUint32(nil, windowAckSize))
+			if err != nil {
+				return "", err
+			}
+
+			// Dynamic limit type
+			err = c.writeControl(typeSetPeerBandwidth, append(binary.BigEndian.AppendUint32(nil, windowAckSize), 2))
+			if err != nil {
+				return "", err
+			}
+
+			err = c.writeCommand(0, "_result", txn, map[string]interface{}{
+				"fmsVer":       "FMS/3,0,1,123",
+				"capabilities": 31,
+			}, map[string]interface{}{
+				"level":          "status",
+				"code":           "NetConnection.Connect.Success",
+				"description":    "Connection succeeded.",
+				"objectEncoding": 0,
+			})
+		case "createStream":
+			err = c.writeCommand(0, "_result", txn, nil, publishStreamID)
+		case "publish":
+			if len(values) < 4 {
+				return "", fmt.Errorf("publish command without a stream key")
+			}
+
+			key, _ := values[3].(string)
+			// Some encoders append parameters to the stream key
+			key, _, _ = strings.Cut(key, "?")
+			return key, nil
+		default:
+			// i.e. releaseStream and FCPublish
+			if txn != 0 {
+				err = c.writeCommand(0, "_result", txn, nil)
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// AcceptPublish tells the publisher to start sending media
+func (c *Conn) AcceptPublish() error {
+	c.messageLimit = maxMessageLength
+
+	// Stream Begin
+	err := c.writeControl(typeUserControl, binary.BigEndian.AppendUint32([]byte{0x00, 0x00}, publishStreamID))
+	if err != nil {
+		return err
+	}
+
+	return c.writeCommand(publishStreamID, "onStatus", 0, nil, map[string]interface{}{
+		"level":       "status",
+		"code":        "NetStream.Publish.Start",
+		"description": "Start publishing",
+	})
+}
+
+// RejectPublish tells the publisher the stream key is not accepted
+func (c *Conn) RejectPublish(description string) error {
+	return c.writeCommand(publishStreamID, "onStatus", 0, nil, map[string]interface{}{
+		"level":       "error",
+		"code":        "NetStream.Publish.BadName",
+		"description": description,
+	})
+}
+
+// ReadVideo blocks until the next H264 video message. It returns io.EOF when the publisher
+// stops publishing.
+func (c *Conn) ReadVideo() (Video, error) {
+	for {
+		msg, err := c.readMessage()
+		if err != nil {
+			return Video{}, err
+		}
+
+		switch msg.typeID {
+		case typeCommandAMF0:
+			values, err := decodeAMF(msg.payload)
+			if err != nil || len(values) == 0 {
+				continue
+			}
+
+			switch values[0] {
+			case "FCUnpublish", "deleteStream", "closeStream":
+				return Video{}, io.EOF
+			}
+		case typeVideo:
+			video, ok, err := c.parseVideo(msg)
+			if err != nil {
+				return Video{}, err
+			}
+
+			if ok {
+				return video, nil
+			}
+		}
+	}
+}
+
+// Close closes the connection
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Conn) parseVideo(msg message) (Video, bool, error) {
+	if len(msg.payload) < 5 {
+		return Video{}, false, nil
+	}
+
+	// Enhanced RTMP (i.e. HEVC) sets the high bit of the frame type
+	codec := msg.payload[0] & 0x0f
+	if msg.payload[0]&0x80 != 0 || codec != codecAVC {
+		return Video{}, false, fmt.Errorf("unsupported video codec %d. Only H264 is supported", codec)
+	}
+
+	// The composition time is a signed 24-bit offset from the decoding time
+	cts := int32(uint32(msg.payload[2])<<16|uint32(msg.payload[3])<<8|uint32(msg.payload[4])) << 8 >> 8
+	video := Video{
+		Time:            msg.timestamp,
+		CompositionTime: cts,
+		Keyframe:        msg.payload[0]>>4 == frameTypeKey,
+	}
+
+	data := msg.payload[5:]
+	switch msg.payload[1] {
+	case avcSequenceHeader:
+		// AVCDecoderConfigurationRecord
+		if len(data) < 8 {
+			return Video{}, false, fmt.Errorf("invalid avc sequence header")
+		}
+
+		c.nalLengthSize = int(data[4]&0x03) + 1
+
+		// Only the first SPS and PPS are kept
+		sps, rest, err := parameterSets(data[5:], 0x1f)
+		if err != nil {
+			return Video{}, false, err
+		}
+
+		pps, _, err := parameterSets(rest, 0xff)
+		if err != nil {
+			return Video{}, false, err
+		}
+
+		video.SPS = sps
+		video.PPS = pps
+		return video, true, nil
+	case avcNALU:
+		au := make([]byte, 0, len(data)+16)
+		for len(data) >= c.nalLengthSize {
+			length := 0
+			for _, b := range data[:c.nalLengthSize] {
+				length = length<<8 | int(b)
+			}
+			data = data[c.nalLengthSize:]
+
+			if length > len(data) {
+				return Video{}, false, fmt.Errorf("invalid avc nal unit length %d", length)
+			}
+
+			au = append(au, 0x00, 0x00, 0x00, 0x01)
+			au = append(au, data[:length]...)
+			data = data[length:]
+		}
+
+		video.AU = au
+		return video, len(au) > 0, nil
+	default:
+		// End of sequence
+		return Video{}, false, nil
+	}
+}
+
+// readMessage reads chunks until a message is complete. Protocol control messages are handled here.
+func (c *Conn) readMessage() (message, error) {
+	for {
+		err := c.conn.SetReadDeadline(time.Now().Add(readTimeout))
+		if err != nil {
+			return message{}, err
+		}
+
+		msg, ok, err := c.readChunk()
+		if err != nil {
+			return message{}, err
+		}
+
+		// Acknowledge the bytes received as advertised in the window size
+		if c.received-c.acked >= windowAckSize {
+			c.acked = c.received
+			err = c.writeControl(typeAcknowledgement, binary.BigEndian.AppendUint32(nil, c.received))
+			if err != nil {
+				return message{}, err
+			}
+		}
+
+		if !ok {
+			continue
+		}
+
+		switch msg.typeID {
+		case typeSetChunkSize:
+			if len(msg.payload) < 4 {
+				return message{}, fmt.Errorf("invalid set chunk size message")
+			}
+			c.chunkSize = binary.BigEndian.Uint32(msg.payload) & 0x7fffffff
+			if c.chunkSize == 0 {
+				return message{}, fmt.Errorf("invalid chunk size 0")
+			}
+		case typeAbort:
+			if len(msg.payload) >= 4 {
+				if cs, ok := c.streams[binary.BigEndian.Uint32(msg.payload)]; ok {
+					cs.payload = nil
+				}
+			}
+		case typeAcknowledgement, typeUserControl, typeWindowAckSize, typeSetPeerBandwidth, typeAudio, typeDataAMF0:
+			// Nothing to do. The audio is not supported.
+		default:
+			return msg, nil
+		}
+	}
+}
+
+// readChunk reads one chunk and returns the message if the chunk completes it
+func (c *Conn) readChunk() (message, bool, error) {
+	b, err := c.reader.ReadByte()
+	if err != nil {
+		return message{}, false, err
+	}
+
+	format := b >> 6
+	csid := uint32(b & 0x3f)
+	switch csid {
+	case 0:
+		b, err = c.reader.ReadByte()
+		csid = 64 + uint32(b)
+	case 1:
+		id := make([]byte, 2)
+		_, err = io.ReadFull(c.reader, id)
+		csid = 64 + uint32(id[0]) + uint32(id[1])*256
+	}
+	if err != nil {
+		return message{}, false, err
+	}
+
+	cs, ok := c.streams[csid]
+	if !ok {
+		if format != 0 {
+			return message{}, false, fmt.Errorf("chunk stream %d does not start with a full header", csid)
+		}
+
+		if len(c.streams) >= maxChunkStreams {
+			return message{}, false, fmt.Errorf("more than %d chunk streams", maxChunkStreams)
+		}
+		cs = &chunkStream{}
+		c.streams[csid] = cs
+	}
+
+	headerSize := [4]int{11, 7, 3, 0}[format]
+	header := make([]byte, headerSize)
+	_, err = io.ReadFull(c.reader, header)
+	if err != nil {
+		return message{}, false, err
+	}
+
+	var timestamp uint32
+	if headerSize >= 3 {
+		timestamp = uint24(header[0:3])
+		cs.extended = timestamp == extendedTimestamp
+	}
+	if headerSize >= 7 {
+		cs.length = uint24(header[3:6])
+		cs.typeID = header[6]
+	}
+	if headerSize == 11 {
+		cs.streamID = binary.LittleEndian.Uint32(header[7:11])
+	}
+
+	if cs.extended {
+		ext := make([]byte, 4)
+		_, err = io.ReadFull(c.reader, ext)
+		if err != nil {
+			return message{}, false, err
+		}
+
+		// Continuation chunks repeat the extended timestamp which must not be applied again
+		if headerSize > 0 || cs.payload == nil {
+			timestamp = binary.BigEndian.Uint32(ext)
+		}
+	}
+
+	// The timestamp of a full header is absolute and the others are deltas
+	switch {
+	case format == 0:
+		cs.timestamp = timestamp
+		cs.delta = timestamp
+	case format < 3:
+		cs.delta = timestamp
+		cs.timestamp += timestamp
+	case cs.payload == nil:
+		// A new message with the same header as the previous one
+		cs.timestamp += cs.delta
+	}
+
+	if cs.length > c.messageLimit {
+		return message{}, false, fmt.Errorf("message length %d is too large", cs.length)
+	}
+
+	if cs.payload == nil {
+		cs.payload = []byte{}
+	}
+
+	// The payload grows as the chunks arrive so a header alone does not allocate the message
+	size := min(c.chunkSize, cs.length-uint32(len(cs.payload)))
+	start := len(cs.payload)
+	cs.payload = slices.Grow(cs.payload, int(size))[:start+int(size)]
+	_, err = io.ReadFull(c.reader, cs.payload[start:])
+	if err != nil {
+		return message{}, false, err
+	}
+
+	if uint32(len(cs.payload)) < cs.length {
+		return message{}, false, nil
+	}
+
+	msg := message{
+		typeID:    cs.typeID,
+		streamID:  cs.streamID,
+		timestamp: cs.timestamp,
+		payload:   cs.payload,
+	}
+	cs.payload = nil
+	return msg, true, nil
+}
+
+func (c *Conn) writeControl(typeID uint8, payload []byte) error {
+	return c.writeMessage(controlChunkID, typeID, 0, payload)
+}
+
+func (c *Conn) writeCommand(streamID uint32, values ...interface{}) error {
+	return c.writeMessage(commandChunkID, typeCommandAMF0, streamID, encodeAMF(values...))
+}
+
+// writeMessage writes the message using the default chunk size. A full header is used for the
+// first chunk and continuation headers for the rest.
+func (c *Conn) writeMessage(csid uint8, typeID uint8, streamID uint32, payload []byte) error {
+	b := make([]byte, 0, 12+len(payload)+len(payload)/defaultChunkSize)
+	b = append(b, csid)
+	b = append(b, 0x00, 0x00, 0x00) // timestamp
+	b = append(b, byte(len(payload)>>16), byte(len(payload)>>8), byte(len(payload)))
+	b = append(b, typeID)
+	b = binary.LittleEndian.AppendUint32(b, streamID)
+
+	for i := 0; i < len(payload); i += defaultChunkSize {
+		if i > 0 {
+			b = append(b, 0xc0|csid)
+		}
+		b = append(b, payload[i:min(i+defaultChunkSize, len(payload))]...)
+	}
+
+	_, err := c.conn.Write(b)
+	return err
+}
+
+// parameterSets reads the count (masked) and the length-prefixed parameter sets of an
+// AVCDecoderConfigurationRecord. It returns the first set and what follows the sets.
+func parameterSets(data []byte, countMask byte) ([]byte, []byte, error) {
+	if len(data) == 0 {
+		return nil, data, nil
+	}
+
+	var first []byte
+	count := int(data[0] & countMask)
+	data = data[1:]
+	for i := 0; i < count; i++ {
+		if len(data) < 2 || len(data) < 2+int(binary.BigEndian.Uint16(data)) {
+			return nil, nil, fmt.Errorf("invalid avc sequence header")
+		}
+
+		length := int(binary.BigEndian.Uint16(data))
+		if first == nil {
+			first = data[2 : 2+length]
+		}
+		data = data[2+length:]
+	}
+
+	return first, data, nil
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
+
+// countingReader counts the bytes read for the acknowledgements
+type countingReader struct {
+	conn  net.Conn
+	count *uint32
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.conn.Read(p)
+	*r.count += uint32(n)
+	return n, err
+}
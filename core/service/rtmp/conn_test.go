@@ -0,0 +1,316 @@
+package rtmp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+)
+
+var (
+	testSPS = []byte{0x67, 0x42, 0xc0, 0x1f, 0xda}
+	testPPS = []byte{0x68, 0xce, 0x3c, 0x80}
+)
+
+// videoTag returns an FLV video tag body i.e. the frame type and codec, the AVC packet type,
+// the composition time and the data
+func videoTag(frameCodec byte, packetType byte, cts int32, data ...byte) []byte {
+	tag := []byte{frameCodec, packetType, byte(cts >> 16), byte(cts >> 8), byte(cts)}
+	return append(tag, data...)
+}
+
+// sequenceHeader returns an AVCDecoderConfigurationRecord with 4-byte NAL unit lengths
+func sequenceHeader(sps []byte, pps []byte) []byte {
+	record := []byte{0x01, sps[1], sps[2], sps[3], 0xff, 0xe1, 0x00, byte(len(sps))}
+	record = append(record, sps...)
+	record = append(record, 0x01, 0x00, byte(len(pps)))
+	return append(record, pps...)
+}
+
+func TestParseVideo(t *testing.T) {
+	idr := []byte{0x65, 0x88, 0x84}
+	slice := []byte{0x41, 0x9a}
+
+	nalus := []byte{0x00, 0x00, 0x00, byte(len(idr))}
+	nalus = append(nalus, idr...)
+	nalus = append(nalus, 0x00, 0x00, 0x00, byte(len(slice)))
+	nalus = append(nalus, slice...)
+
+	annexB := []byte{0x00, 0x00, 0x00, 0x01}
+	annexB = append(annexB, idr...)
+	annexB = append(annexB, 0x00, 0x00, 0x00, 0x01)
+	annexB = append(annexB, slice...)
+
+	tests := []struct {
+		name    string
+		payload []byte
+		video   Video
+		ok      bool
+		fails   bool
+	}{
+		{
+			name:    "sequence header",
+			payload: videoTag(0x17, avcSequenceHeader, 0, sequenceHeader(testSPS, testPPS)...),
+			video:   Video{Time: 1000, Keyframe: true, SPS: testSPS, PPS: testPPS},
+			ok:      true,
+		},
+		{
+			name:    "keyframe",
+			payload: videoTag(0x17, avcNALU, 0, nalus...),
+			video:   Video{Time: 1000, Keyframe: true, AU: annexB},
+			ok:      true,
+		},
+		{
+			name:    "b-frame composition time",
+			payload: videoTag(0x27, avcNALU, 80, nalus...),
+			video:   Video{Time: 1000, CompositionTime: 80, AU: annexB},
+			ok:      true,
+		},
+		{
+			name:    "negative composition time",
+			payload: videoTag(0x27, avcNALU, -40, nalus...),
+			video:   Video{Time: 1000, CompositionTime: -40, AU: annexB},
+			ok:      true,
+		},
+		{
+			name:    "trailing bytes shorter than a length are dropped",
+			payload: videoTag(0x27, avcNALU, 0, append(nalus, 0x00, 0x00)...),
+			video:   Video{Time: 1000, AU: annexB},
+			ok:      true,
+		},
+		{
+			name:    "end of sequence",
+			payload: videoTag(0x17, 0x02, 0),
+		},
+		{
+			name:    "truncated tag",
+			payload: []byte{0x17, avcNALU, 0x00},
+		},
+		{
+			name:    "no nal units",
+			payload: videoTag(0x27, avcNALU, 0),
+			video:   Video{Time: 1000},
+		},
+		{
+			name:    "unsupported codec",
+			payload: videoTag(0x12, avcNALU, 0, nalus...),
+			fails:   true,
+		},
+		{
+			name:    "enhanced rtmp",
+			payload: videoTag(0x90, avcNALU, 0, nalus...),
+			fails:   true,
+		},
+		{
+			name:    "nal unit longer than the message",
+			payload: videoTag(0x17, avcNALU, 0, 0x00, 0x00, 0x01, 0x00, 0x65),
+			fails:   true,
+		},
+		{
+			name:    "short sequence header",
+			payload: videoTag(0x17, avcSequenceHeader, 0, 0x01, 0x42, 0xc0),
+			fails:   true,
+		},
+		{
+			name:    "truncated sps",
+			payload: videoTag(0x17, avcSequenceHeader, 0, sequenceHeader(testSPS, testPPS)[:10]...),
+			fails:   true,
+		},
+		{
+			name:    "truncated pps",
+			payload: videoTag(0x17, avcSequenceHeader, 0, sequenceHeader(testSPS, testPPS)[:len(sequenceHeader(testSPS, testPPS))-1]...),
+			fails:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &Conn{nalLengthSize: 4}
+			video, ok, err := c.parseVideo(message{
+				typeID:    typeVideo,
+				streamID:  publishStreamID,
+				timestamp: 1000,
+				payload:   test.payload,
+			})
+
+			if (err != nil) != test.fails || ok != test.ok {
+				t.Fatalf("got ok %v error %v, want ok %v failure %v", ok, err, test.ok, test.fails)
+			}
+
+			if video.Time != test.video.Time || video.CompositionTime != test.video.CompositionTime || video.Keyframe != test.video.Keyframe {
+				t.Fatalf("got %+v, want %+v", video, test.video)
+			}
+
+			if !bytes.Equal(video.SPS, test.video.SPS) || !bytes.Equal(video.PPS, test.video.PPS) || !bytes.Equal(video.AU, test.video.AU) {
+				t.Fatalf("got %+v, want %+v", video, test.video)
+			}
+		})
+	}
+}
+
+func TestParseVideoNALLengthSize(t *testing.T) {
+	c := &Conn{nalLengthSize: 4}
+
+	// The sequence header sets 2-byte NAL unit lengths
+	record := sequenceHeader(testSPS, testPPS)
+	record[4] = 0xfd
+	_, _, err := c.parseVideo(message{payload: videoTag(0x17, avcSequenceHeader, 0, record...)})
+	if err != nil {
+		t.Fatalf("parseVideo error: %v", err)
+	}
+
+	video, ok, err := c.parseVideo(message{payload: videoTag(0x17, avcNALU, 0, 0x00, 0x02, 0x65, 0x88)})
+	if err != nil || !ok {
+		t.Fatalf("got ok %v error %v", ok, err)
+	}
+
+	if want := []byte{0x00, 0x00, 0x00, 0x01, 0x65, 0x88}; !bytes.Equal(video.AU, want) {
+		t.Fatalf("got %x, want %x", video.AU, want)
+	}
+}
+
+func TestVideoPresentationTime(t *testing.T) {
+	tests := []struct {
+		name  string
+		video Video
+		time  uint32
+	}{
+		{
+			name:  "no composition time",
+			video: Video{Time: 1000},
+			time:  1000,
+		},
+		{
+			name:  "b-frame",
+			video: Video{Time: 1000, CompositionTime: 80},
+			time:  1080,
+		},
+		{
+			name:  "negative composition time",
+			video: Video{Time: 1000, CompositionTime: -40},
+			time:  960,
+		},
+		{
+			name:  "wraps around",
+			video: Video{Time: 0xffffffff, CompositionTime: 2},
+			time:  1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.video.PresentationTime(); got != test.time {
+				t.Fatalf("got %d, want %d", got, test.time)
+			}
+		})
+	}
+}
+
+// chunks returns the message split in chunks of the default size on the chunk stream
+func chunks(csid byte, typeID byte, length int, payload []byte) []byte {
+	b := []byte{csid, 0x00, 0x00, 0x00, byte(length >> 16), byte(length >> 8), byte(length), typeID, 0x00, 0x00, 0x00, 0x00}
+	for i := 0; i < len(payload); i += defaultChunkSize {
+		if i > 0 {
+			b = append(b, 0xc0|csid)
+		}
+		b = append(b, payload[i:min(i+defaultChunkSize, len(payload))]...)
+	}
+
+	return b
+}
+
+func TestReadChunk(t *testing.T) {
+	command := bytes.Repeat([]byte{0x05}, 300)
+
+	tests := []struct {
+		name       string
+		data       []byte
+		publishing bool
+		messages   int
+		fails      bool
+	}{
+		{
+			name:     "message over chunks",
+			data:     chunks(3, typeCommandAMF0, len(command), command),
+			messages: 1,
+		},
+		{
+			name:     "interleaved chunk streams",
+			data:     append(chunks(3, typeCommandAMF0, 10, make([]byte, 10)), chunks(4, typeCommandAMF0, 10, make([]byte, 10))...),
+			messages: 2,
+		},
+		{
+			name:  "media message before publishing",
+			data:  chunks(4, typeVideo, maxCommandLength+1, nil),
+			fails: true,
+		},
+		{
+			name:       "media message while publishing",
+			data:       chunks(4, typeVideo, maxCommandLength+1, make([]byte, maxCommandLength+1)),
+			publishing: true,
+			messages:   1,
+		},
+		{
+			name: "too many chunk streams",
+			data: func() []byte {
+				b := []byte{}
+				for csid := byte(3); csid < 3+maxChunkStreams+1; csid++ {
+					// The first chunk of a message still waiting for its other chunks
+					b = append(b, chunks(csid, typeCommandAMF0, 1000, make([]byte, defaultChunkSize))...)
+				}
+				return b
+			}(),
+			fails: true,
+		},
+		{
+			name:  "continuation without a full header",
+			data:  []byte{0xc3, 0x00},
+			fails: true,
+		},
+		{
+			name:  "truncated header",
+			data:  []byte{0x03, 0x00, 0x00},
+			fails: true,
+		},
+		{
+			name:  "truncated payload",
+			data:  chunks(3, typeCommandAMF0, 100, make([]byte, 50)),
+			fails: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := NewConn(nil)
+			c.reader = bufio.NewReader(bytes.NewReader(test.data))
+			if test.publishing {
+				c.messageLimit = maxMessageLength
+			}
+
+			messages := 0
+			var err error
+			for {
+				var ok bool
+				_, ok, err = c.readChunk()
+				if err != nil {
+					break
+				}
+
+				if ok {
+					messages++
+				}
+			}
+
+			if fails := err != io.EOF; fails != test.fails || messages != test.messages {
+				t.Fatalf("got %d messages and error %v, want %d messages and failure %v", messages, err, test.messages, test.fails)
+			}
+
+			// Headers alone do not allocate the messages
+			for csid, cs := range c.streams {
+				if cap(cs.payload) > 2*int(cs.length) && cap(cs.payload) > 2*defaultChunkSize {
+					t.Fatalf("chunk stream %d holds %d bytes for %d", csid, cap(cs.payload), len(cs.payload))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package rtsp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testClient returns a client reading what the server writes
+func testClient(t *testing.T, server string) *Client {
+	t.Helper()
+
+	clientConn, serverConn := net.Pipe()
+	t.Cleanup(func() {
+		_ = clientConn.Close()
+		_ = serverConn.Close()
+	})
+
+	go func() {
+		// Drain the requests
+		go func() {
+			_, _ = bufio.NewReader(serverConn).WriteTo(&bytes.Buffer{})
+		}()
+
+		_, _ = serverConn.Write([]byte(server))
+	}()
+
+	return &Client{
+		conn:        clientConn,
+		reader:      bufio.NewReader(clientConn),
+		readTimeout: 100 * time.Millisecond,
+	}
+}
+
+func interleaved(channel byte, payload ...byte) string {
+	return string(append([]byte{interleavedMarker, channel, 0x00, byte(len(payload))}, payload...))
+}
+
+func TestReadRTP(t *testing.T) {
+	tests := []struct {
+		name     string
+		server   string
+		payloads []string
+		timeout  bool
+	}{
+		{
+			name:     "rtp",
+			server:   interleaved(rtpChannel, 0x80, 0x60) + interleaved(rtpChannel, 0x80, 0x61),
+			payloads: []string{"\x80\x60", "\x80\x61"},
+			timeout:  true,
+		},
+		{
+			name:     "rtcp and responses are skipped",
+			server:   interleaved(rtpChannel+1, 0x81, 0xc8) + "RTSP/1.0 200 OK\r\nCSeq: 5\r\n\r\n" + interleaved(rtpChannel, 0x80, 0x60),
+			payloads: []string{"\x80\x60"},
+			timeout:  true,
+		},
+		{
+			name:    "camera stops sending",
+			server:  "",
+			timeout: true,
+		},
+		{
+			name:   "invalid response",
+			server: "HTTP/1.1 200 OK\r\n\r\n",
+		},
+		{
+			name:    "camera stops in the middle of a packet",
+			server:  interleaved(rtpChannel, 0x80, 0x60)[:5],
+			timeout: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := testClient(t, test.server)
+
+			for _, want := range test.payloads {
+				payload, err := c.ReadRTP()
+				if err != nil || string(payload) != want {
+					t.Fatalf("got %x error %v, want %x", payload, err, want)
+				}
+			}
+
+			_, err := c.ReadRTP()
+			if err == nil || errors.Is(err, os.ErrDeadlineExceeded) != test.timeout {
+				t.Fatalf("got error %v, want timeout %v", err, test.timeout)
+			}
+		})
+	}
+}
+
+func TestDo(t *testing.T) {
+	tests := []struct {
+		name   string
+		server string
+		body   string
+		fails  bool
+	}{
+		{
+			name:   "description",
+			server: "RTSP/1.0 200 OK\r\nCSeq: 1\r\nContent-Length: 4\r\n\r\nv=0\n",
+			body:   "v=0\n",
+		},
+		{
+			name:   "content length over the limit",
+			server: "RTSP/1.0 200 OK\r\nCSeq: 1\r\nContent-Length: 2000000000\r\n\r\n",
+			fails:  true,
+		},
+		{
+			name:   "negative content length",
+			server: "RTSP/1.0 200 OK\r\nCSeq: 1\r\nContent-Length: -1\r\n\r\n",
+			fails:  true,
+		},
+		{
+			name:   "status",
+			server: "RTSP/1.0 404 Not Found\r\nCSeq: 1\r\n\r\n",
+			fails:  true,
+		},
+		{
+			name:   "no response",
+			server: "",
+			fails:  true,
+		},
+		{
+			name:   "truncated headers",
+			server: "RTSP/1.0 200 OK\r\nCSeq: 1\r\n",
+			fails:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := testClient(t, test.server)
+
+			resp, err := c.Do("DESCRIBE", "rtsp://camera/stream", nil)
+			if (err != nil) != test.fails {
+				t.Fatalf("got error %v, want failure %v", err, test.fails)
+			}
+
+			if err == nil && string(resp.Body) != test.body {
+				t.Fatalf("got body %q, want %q", resp.Body, test.body)
+			}
+
+			if err != nil && strings.Contains(test.name, "content length") && !strings.Contains(err.Error(), "content length") {
+				t.Fatalf("got error %v, want a content length error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package rtsp
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Media is the H264 video media described by the RTSP server
+type Media struct {
+	ControlURL  string
+	PayloadType uint8
+	// Parameter sets announced out of band i.e. `sprop-parameter-sets`. Many cameras do not repeat them in-band.
+	SPS []byte
+	PPS []byte
+}
+
+// FindH264 looks up the H264 video media in the SDP and resolves its control URL against the base URL
+func FindH264(sdp string, base string) (Media, error) {
+	media := Media{}
+	found := false
+	inVideo := false
+	control := ""
+	payloadType := ""
+	// Format parameters of the current media by payload type
+	fmtps := map[string]string{}
+	for _, line := range strings.Split(sdp, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "m="):
+			if found {
+				return resolve(parameterSets(media, fmtps[payloadType]), base, control), nil
+			}
+			inVideo = strings.HasPrefix(line, "m=video")
+			control = ""
+			fmtps = map[string]string{}
+		case !inVideo:
+			continue
+		case strings.HasPrefix(line, "a=rtpmap:"):
+			// i.e. `a=rtpmap:96 H264/90000`
+			pt, encoding, _ := strings.Cut(strings.TrimPrefix(line, "a=rtpmap:"), " ")
+			if found || !strings.HasPrefix(strings.ToUpper(encoding), "H264/") {
+				continue
+			}
+
+			n, err := strconv.ParseUint(pt, 10, 8)
+			if err != nil {
+				return media, fmt.Errorf("invalid H264 payload type: %v", err)
+			}
+			media.PayloadType = uint8(n)
+			payloadType = pt
+			found = true
+		case strings.HasPrefix(line, "a=fmtp:"):
+			pt, params, _ := strings.Cut(strings.TrimPrefix(line, "a=fmtp:"), " ")
+			fmtps[pt] = params
+		case strings.HasPrefix(line, "a=control:"):
+			control = strings.TrimPrefix(line, "a=control:")
+		}
+	}
+
+	if !found {
+		return media, fmt.Errorf("no H264 video in the stream description")
+	}
+
+	return resolve(parameterSets(media, fmtps[payloadType]), base, control), nil
+}
+
+// parameterSets sets the parameter sets announced in the H264 format parameters. Sets that do not
+// decode are ignored so the ones sent in-band are used instead.
+func parameterSets(media Media, params string) Media {
+	for _, param := range strings.Split(params, ";") {
+		value, ok := strings.CutPrefix(strings.TrimSpace(param), "sprop-parameter-sets=")
+		if !ok {
+			continue
+		}
+
+		sets := strings.Split(value, ",")
+		sps, err := decodeParameterSet(sets[0])
+		if err != nil {
+			return media
+		}
+		media.SPS = sps
+
+		if len(sets) > 1 {
+			pps, err := decodeParameterSet(sets[1])
+			if err == nil {
+				media.PPS = pps
+			}
+		}
+	}
+
+	return media
+}
+
+// decodeParameterSet decodes a base64 parameter set. Some cameras drop the padding.
+func decodeParameterSet(set string) ([]byte, error) {
+	b, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(strings.TrimSpace(set), "="))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) == 0 {
+		return nil, fmt.Errorf("empty parameter set")
+	}
+
+	return b, nil
+}
+
+func resolve(media Media, base string, control string) Media {
+	switch {
+	case control == "" || control == "*":
+		media.ControlURL = base
+	case strings.HasPrefix(control, "rtsp://"):
+		media.ControlURL = control
+	default:
+		media.ControlURL = strings.TrimSuffix(base, "/") + "/" + control
+	}
+
+	return media
+}
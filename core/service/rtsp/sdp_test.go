@@ -0,0 +1,176 @@
+package rtsp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const testBase = "rtsp://camera:554/stream"
+
+// testSDP joins the lines after the session description header
+func testSDP(lines ...string) string {
+	header := []string{"v=0", "o=- 0 0 IN IP4 127.0.0.1", "s=camera", "t=0 0", "a=control:*"}
+	return strings.Join(append(header, lines...), "\r\n") + "\r\n"
+}
+
+func TestFindH264(t *testing.T) {
+	sps := []byte{0x67, 0x42, 0xc0, 0x1f}
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+
+	tests := []struct {
+		name  string
+		sdp   string
+		media Media
+		fails bool
+	}{
+		{
+			name: "video",
+			sdp: testSDP(
+				"m=video 0 RTP/AVP 96",
+				"a=rtpmap:96 H264/90000",
+				"a=fmtp:96 packetization-mode=1;profile-level-id=42c01f;sprop-parameter-sets=Z0LAHw==,aM48gA==",
+				"a=control:trackID=1",
+			),
+			media: Media{ControlURL: testBase + "/trackID=1", PayloadType: 96, SPS: sps, PPS: pps},
+		},
+		{
+			name: "audio before video",
+			sdp: testSDP(
+				"m=audio 0 RTP/AVP 97",
+				"a=rtpmap:97 MPEG4-GENERIC/16000/1",
+				"a=control:trackID=0",
+				"m=video 0 RTP/AVP 96",
+				"a=rtpmap:96 h264/90000",
+				"a=control:rtsp://camera:554/stream/video",
+				"m=application 0 RTP/AVP 107",
+				"a=control:trackID=2",
+			),
+			media: Media{ControlURL: "rtsp://camera:554/stream/video", PayloadType: 96},
+		},
+		{
+			name: "aggregate control",
+			sdp: testSDP(
+				"m=video 0 RTP/AVP 96",
+				"a=rtpmap:96 H264/90000",
+				"a=control:*",
+			),
+			media: Media{ControlURL: testBase, PayloadType: 96},
+		},
+		{
+			name: "first H264 payload type",
+			sdp: testSDP(
+				"m=video 0 RTP/AVP 98 96 97",
+				"a=rtpmap:98 H265/90000",
+				"a=fmtp:98 sprop-parameter-sets=QAEMAf//",
+				"a=rtpmap:96 H264/90000",
+				"a=rtpmap:97 H264/90000",
+				"a=fmtp:97 sprop-parameter-sets=aM48gA==,Z0LAHw==",
+				"a=fmtp:96 sprop-parameter-sets=Z0LAHw==,aM48gA==",
+			),
+			media: Media{ControlURL: testBase, PayloadType: 96, SPS: sps, PPS: pps},
+		},
+		{
+			name: "parameter sets without padding",
+			sdp: testSDP(
+				"m=video 0 RTP/AVP 96",
+				"a=rtpmap:96 H264/90000",
+				"a=fmtp:96 sprop-parameter-sets=Z0LAHw,aM48gA",
+			),
+			media: Media{ControlURL: testBase, PayloadType: 96, SPS: sps, PPS: pps},
+		},
+		{
+			name: "malformed parameter sets are ignored",
+			sdp: testSDP(
+				"m=video 0 RTP/AVP 96",
+				"a=rtpmap:96 H264/90000",
+				"a=fmtp:96 sprop-parameter-sets=Z0L!Hw==,aM48gA==",
+			),
+			media: Media{ControlURL: testBase, PayloadType: 96},
+		},
+		{
+			name: "malformed pps is ignored",
+			sdp: testSDP(
+				"m=video 0 RTP/AVP 96",
+				"a=rtpmap:96 H264/90000",
+				"a=fmtp:96 sprop-parameter-sets=Z0LAHw==,aM4$",
+			),
+			media: Media{ControlURL: testBase, PayloadType: 96, SPS: sps},
+		},
+		{
+			name: "empty parameter sets are ignored",
+			sdp: testSDP(
+				"m=video 0 RTP/AVP 96",
+				"a=rtpmap:96 H264/90000",
+				"a=fmtp:96 packetization-mode=1;sprop-parameter-sets=",
+			),
+			media: Media{ControlURL: testBase, PayloadType: 96},
+		},
+		{
+			name: "fmtp without parameters",
+			sdp: testSDP(
+				"m=video 0 RTP/AVP 96",
+				"a=rtpmap:96 H264/90000",
+				"a=fmtp:96",
+			),
+			media: Media{ControlURL: testBase, PayloadType: 96},
+		},
+		{
+			name: "no H264",
+			sdp: testSDP(
+				"m=video 0 RTP/AVP 96",
+				"a=rtpmap:96 H265/90000",
+				"m=audio 0 RTP/AVP 97",
+				"a=rtpmap:97 H264/90000",
+			),
+			fails: true,
+		},
+		{
+			name:  "no media",
+			sdp:   testSDP(),
+			fails: true,
+		},
+		{
+			name:  "not an sdp",
+			sdp:   "RTSP/1.0 200 OK",
+			fails: true,
+		},
+		{
+			name: "invalid payload type",
+			sdp: testSDP(
+				"m=video 0 RTP/AVP 960",
+				"a=rtpmap:960 H264/90000",
+			),
+			fails: true,
+		},
+		{
+			name: "rtpmap without an encoding",
+			sdp: testSDP(
+				"m=video 0 RTP/AVP 96",
+				"a=rtpmap:96",
+			),
+			fails: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			media, err := FindH264(test.sdp, testBase)
+			if (err != nil) != test.fails {
+				t.Fatalf("got error %v, want failure %v", err, test.fails)
+			}
+
+			if test.fails {
+				return
+			}
+
+			if media.ControlURL != test.media.ControlURL || media.PayloadType != test.media.PayloadType {
+				t.Fatalf("got %+v, want %+v", media, test.media)
+			}
+
+			if !bytes.Equal(media.SPS, test.media.SPS) || !bytes.Equal(media.PPS, test.media.PPS) {
+				t.Fatalf("got parameter sets %x %x, want %x %x", media.SPS, media.PPS, test.media.SPS, test.media.PPS)
+			}
+		})
+	}
+}
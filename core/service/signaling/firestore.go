@@ -0,0 +1,314 @@
+package signaling
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	firebase "firebase.google.com/go/v4"
+
+	"github.com/khaledhikmat/family-meeting/service/lgr"
+	"github.com/khaledhikmat/family-meeting/utils"
+)
+
+const (
+	requestsCollection   = "broadcast_requests"
+	recordingsCollection = "broadcast_recordings"
+	abortWatcherInterval = 5 * time.Second
+)
+
+type firestoreStore struct {
+	db *firestore.Client
+}
+
+// NewFirestore creates a Firestore-backed signaling store. It requires
+// GOOGLE_APPLICATION_CREDENTIALS to be set.
+func NewFirestore(canxCtx context.Context) (SignalingStore, error) {
+	app, err := firebase.NewApp(canxCtx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("initializing firebase app: %v", err)
+	}
+
+	db, err := app.Firestore(canxCtx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring firestore client: %v", err)
+	}
+
+	return &firestoreStore{
+		db: db,
+	}, nil
+}
+
+func (s *firestoreStore) CreateRequest(canxCtx context.Context, request utils.Request) (string, error) {
+	reqDoc := s.db.Collection(requestsCollection).NewDoc()
+	_, err := reqDoc.Set(canxCtx, request)
+	if err != nil {
+		return "", err
+	}
+
+	return reqDoc.ID, nil
+}
+
+func (s *firestoreStore) GetRequest(canxCtx context.Context, id string) (utils.Request, error) {
+	docSnap, err := s.db.Collection(requestsCollection).Doc(id).Get(canxCtx)
+	if err != nil {
+		return utils.Request{}, err
+	}
+
+	return toRequest(docSnap)
+}
+
+func (s *firestoreStore) UpdateRequest(canxCtx context.Context, id string, fields map[string]interface{}) error {
+	updates := []firestore.Update{}
+	for path, value := range fields {
+		updates = append(updates, firestore.Update{
+			Path:  path,
+			Value: value,
+		})
+	}
+
+	_, err := s.db.Collection(requestsCollection).Doc(id).Update(canxCtx, updates)
+	return err
+}
+
+func (s *firestoreStore) CreateRecording(canxCtx context.Context, recording utils.Recording) (string, error) {
+	recDoc := s.db.Collection(recordingsCollection).NewDoc()
+	_, err := recDoc.Set(canxCtx, recording)
+	if err != nil {
+		return "", err
+	}
+
+	return recDoc.ID, nil
+}
+
+func (s *firestoreStore) SetAnswer(canxCtx context.Context, id string, answer string) error {
+	return s.UpdateRequest(canxCtx, id, map[string]interface{}{
+		"answer": answer,
+	})
+}
+
+func (s *firestoreStore) SetStatus(canxCtx context.Context, id string, transition utils.Transition) error {
+	// The current status is checked in a transaction so concurrent instances cannot skip a status
+	reqDoc := s.db.Collection(requestsCollection).Doc(id)
+	return s.db.RunTransaction(canxCtx, func(_ context.Context, tx *firestore.Transaction) error {
+		docSnap, err := tx.Get(reqDoc)
+		if err != nil {
+			return err
+		}
+
+		request, err := toRequest(docSnap)
+		if err != nil {
+			return err
+		}
+
+		if !utils.CanTransition(request.Status, transition.Status) {
+			return fmt.Errorf("%w: %s to %s", ErrStatusTransition, request.Status, transition.Status)
+		}
+
+		updates := []firestore.Update{
+			{Path: "status", Value: transition.Status},
+			{Path: "instance", Value: transition.Instance},
+			{Path: "transitions", Value: firestore.ArrayUnion(transition)},
+		}
+
+		if transition.Status == utils.StatusFailed {
+			updates = append(updates, firestore.Update{Path: "failureReason", Value: transition.Reason})
+		}
+
+		return tx.Update(reqDoc, updates)
+	})
+}
+
+func (s *firestoreStore) AddCandidate(canxCtx context.Context, id string, field string, candidate string) error {
+	return s.UpdateRequest(canxCtx, id, map[string]interface{}{
+		field: firestore.ArrayUnion(candidate),
+	})
+}
+
+func (s *firestoreStore) WatchRequests(canxCtx context.Context,
+	requestCanxCtx context.Context,
+	errorStream chan error,
+	kind string,
+	parent string) chan utils.Request {
+	query := s.db.Collection(requestsCollection).
+		Where("kind", "==", kind).
+		Where("parent", "==", parent).
+		Where("offer", "!=", "").
+		Where("answer", "==", "").
+		Where("abort", "==", false)
+	//q := reqRef.OrderBy("createdAt", firestore.Desc).Limit(3)
+
+	return s.watchAdded(canxCtx, requestCanxCtx, errorStream, query)
+}
+
+func (s *firestoreStore) WatchSourceRequests(canxCtx context.Context,
+	requestCanxCtx context.Context,
+	errorStream chan error,
+	kind string) chan utils.Request {
+	query := s.db.Collection(requestsCollection).
+		Where("kind", "==", kind).
+		Where("url", "!=", "").
+		Where("answer", "==", "").
+		Where("abort", "==", false)
+
+	return s.watchAdded(canxCtx, requestCanxCtx, errorStream, query)
+}
+
+// watchAdded streams the requests added to the query results
+func (s *firestoreStore) watchAdded(canxCtx context.Context,
+	requestCanxCtx context.Context,
+	errorStream chan error,
+	query firestore.Query) chan utils.Request {
+	requestsChan := make(chan utils.Request)
+
+	go func() {
+		defer close(requestsChan)
+
+		watchCtx := watchContext(canxCtx, requestCanxCtx)
+		iter := query.Snapshots(watchCtx)
+		defer iter.Stop()
+
+		for {
+			if canxCtx.Err() != nil {
+				errorStream <- fmt.Errorf("watchRequests context cancelled: %v", canxCtx.Err())
+				return
+			}
+
+			if requestCanxCtx != nil && requestCanxCtx.Err() != nil {
+				errorStream <- fmt.Errorf("watchRequests parent context cancelled: %v", requestCanxCtx.Err())
+				return
+			}
+
+			snap, err := iter.Next()
+			if err != nil {
+				errorStream <- fmt.Errorf("watchRequests error getting snapshot: %v", err)
+				continue
+			}
+			for _, change := range snap.Changes {
+				if change.Kind != firestore.DocumentAdded {
+					continue
+				}
+
+				request, err := toRequest(change.Doc)
+				if err != nil {
+					errorStream <- fmt.Errorf("watchRequests error getting request data: %v", err)
+					continue
+				}
+
+				select {
+				case <-watchCtx.Done():
+					return
+				case requestsChan <- request:
+				}
+			}
+		}
+	}()
+
+	return requestsChan
+}
+
+func (s *firestoreStore) WatchRequest(canxCtx context.Context,
+	requestCanxCtx context.Context,
+	errorStream chan error,
+	id string) chan utils.Request {
+	requestChan := make(chan utils.Request)
+
+	go func() {
+		defer close(requestChan)
+
+		watchCtx := watchContext(canxCtx, requestCanxCtx)
+		iter := s.db.Collection(requestsCollection).Doc(id).Snapshots(watchCtx)
+		defer iter.Stop()
+
+		for {
+			if watchCtx.Err() != nil {
+				return
+			}
+
+			snap, err := iter.Next()
+			if err != nil {
+				if watchCtx.Err() == nil {
+					errorStream <- fmt.Errorf("watchRequest error getting snapshot: %v", err)
+				}
+				continue
+			}
+
+			if !snap.Exists() {
+				continue
+			}
+
+			request, err := toRequest(snap)
+			if err != nil {
+				errorStream <- fmt.Errorf("watchRequest error getting request data: %v", err)
+				continue
+			}
+
+			select {
+			case <-watchCtx.Done():
+				return
+			case requestChan <- request:
+			}
+		}
+	}()
+
+	return requestChan
+}
+
+func (s *firestoreStore) WatchAbort(canxCtx context.Context,
+	requestCanxCtx context.Context,
+	errorStream chan error,
+	id string) chan struct{} {
+	abortChan := make(chan struct{})
+
+	go func() {
+		defer close(abortChan)
+
+		watchCtx := watchContext(canxCtx, requestCanxCtx)
+		ticker := time.NewTicker(abortWatcherInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-watchCtx.Done():
+				lgr.Logger.Info("abortWatcher context cancelled")
+				return
+			case <-ticker.C:
+				request, err := s.GetRequest(watchCtx, id)
+				if err != nil {
+					errorStream <- fmt.Errorf("abortWatcher failed to get request: %v", err)
+					continue
+				}
+
+				if request.Abort {
+					lgr.Logger.Info("abortWatcher detected an abort",
+						slog.String("request", id),
+					)
+					select {
+					case <-watchCtx.Done():
+					case abortChan <- struct{}{}:
+					}
+					return
+				}
+			}
+		}
+	}()
+
+	return abortChan
+}
+
+func (s *firestoreStore) Close() error {
+	return s.db.Close()
+}
+
+func toRequest(snap *firestore.DocumentSnapshot) (utils.Request, error) {
+	request := utils.Request{}
+	err := snap.DataTo(&request)
+	if err != nil {
+		return request, err
+	}
+
+	request.ID = snap.Ref.ID
+	return request, nil
+}
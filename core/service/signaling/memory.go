@@ -0,0 +1,391 @@
+package signaling
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/khaledhikmat/family-meeting/service/lgr"
+	"github.com/khaledhikmat/family-meeting/utils"
+)
+
+// memoryStore is an in-process signaling store. It mimics the Firestore
+// snapshot semantics (i.e. a request is `added` to a watch when it starts
+// matching the watch filters) so modes behave the same without a Firebase project.
+type memoryStore struct {
+	mutex    sync.Mutex
+	requests map[string]utils.Request
+	versions map[string]int
+	order    []string
+	signals  map[chan struct{}]struct{}
+	// Recordings are not watched so they are simply kept
+	recordings map[string]utils.Recording
+}
+
+// NewMemory creates an in-memory signaling store. Requests are only visible
+// to the process that created the store.
+func NewMemory(_ context.Context) (SignalingStore, error) {
+	return &memoryStore{
+		requests: map[string]utils.Request{},
+		versions: map[string]int{},
+		order:    []string{},
+		signals:  map[chan struct{}]struct{}{},
+
+		recordings: map[string]utils.Recording{},
+	}, nil
+}
+
+// IsMemory tells if the store is the in-memory store i.e. only this process can answer its requests
+func IsMemory(store SignalingStore) bool {
+	_, ok := store.(*memoryStore)
+	return ok
+}
+
+func (s *memoryStore) CreateRequest(_ context.Context, request utils.Request) (string, error) {
+	id, err := newID()
+	if err != nil {
+		return "", err
+	}
+
+	s.mutex.Lock()
+	request.ID = id
+	s.requests[id] = request
+	s.versions[id]++
+	s.order = append(s.order, id)
+	s.notify()
+	s.mutex.Unlock()
+
+	return id, nil
+}
+
+func (s *memoryStore) GetRequest(_ context.Context, id string) (utils.Request, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	request, ok := s.requests[id]
+	if !ok {
+		return utils.Request{}, fmt.Errorf("request %s not found", id)
+	}
+
+	return request, nil
+}
+
+func (s *memoryStore) UpdateRequest(_ context.Context, id string, fields map[string]interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	request, ok := s.requests[id]
+	if !ok {
+		return fmt.Errorf("request %s not found", id)
+	}
+
+	// Apply the fields using the request JSON names which match the stored names
+	b, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	doc := map[string]interface{}{}
+	err = json.Unmarshal(b, &doc)
+	if err != nil {
+		return err
+	}
+
+	for path, value := range fields {
+		doc[path] = value
+	}
+
+	b, err = json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	updated := utils.Request{}
+	err = json.Unmarshal(b, &updated)
+	if err != nil {
+		return err
+	}
+
+	updated.ID = id
+	s.requests[id] = updated
+	s.versions[id]++
+	s.notify()
+
+	return nil
+}
+
+func (s *memoryStore) SetAnswer(canxCtx context.Context, id string, answer string) error {
+	return s.UpdateRequest(canxCtx, id, map[string]interface{}{
+		"answer": answer,
+	})
+}
+
+func (s *memoryStore) SetStatus(_ context.Context, id string, transition utils.Transition) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	request, ok := s.requests[id]
+	if !ok {
+		return fmt.Errorf("request %s not found", id)
+	}
+
+	if !utils.CanTransition(request.Status, transition.Status) {
+		return fmt.Errorf("%w: %s to %s", ErrStatusTransition, request.Status, transition.Status)
+	}
+
+	request.Status = transition.Status
+	request.Instance = transition.Instance
+	if transition.Status == utils.StatusFailed {
+		request.FailureReason = transition.Reason
+	}
+	request.Transitions = append(request.Transitions, transition)
+
+	s.requests[id] = request
+	s.versions[id]++
+	s.notify()
+
+	return nil
+}
+
+func (s *memoryStore) AddCandidate(_ context.Context, id string, field string, candidate string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	request, ok := s.requests[id]
+	if !ok {
+		return fmt.Errorf("request %s not found", id)
+	}
+
+	switch field {
+	case utils.OfferCandidates:
+		request.OfferCandidates = append(request.OfferCandidates, candidate)
+	case utils.AnswerCandidates:
+		request.AnswerCandidates = append(request.AnswerCandidates, candidate)
+	default:
+		return fmt.Errorf("unknown candidates field %s", field)
+	}
+
+	s.requests[id] = request
+	s.versions[id]++
+	s.notify()
+
+	return nil
+}
+
+func (s *memoryStore) CreateRecording(_ context.Context, recording utils.Recording) (string, error) {
+	id, err := newID()
+	if err != nil {
+		return "", err
+	}
+
+	s.mutex.Lock()
+	recording.ID = id
+	s.recordings[id] = recording
+	s.mutex.Unlock()
+
+	return id, nil
+}
+
+func (s *memoryStore) WatchRequests(canxCtx context.Context,
+	requestCanxCtx context.Context,
+	errorStream chan error,
+	kind string,
+	parent string) chan utils.Request {
+	return s.watchAdded(canxCtx, requestCanxCtx, errorStream, func(request utils.Request) bool {
+		return request.Kind == kind &&
+			request.Parent == parent &&
+			request.Offer != "" &&
+			request.Answer == "" &&
+			!request.Abort
+	})
+}
+
+func (s *memoryStore) WatchSourceRequests(canxCtx context.Context,
+	requestCanxCtx context.Context,
+	errorStream chan error,
+	kind string) chan utils.Request {
+	return s.watchAdded(canxCtx, requestCanxCtx, errorStream, func(request utils.Request) bool {
+		return request.Kind == kind &&
+			request.URL != "" &&
+			request.Answer == "" &&
+			!request.Abort
+	})
+}
+
+// watchAdded streams the requests as soon as they start matching
+func (s *memoryStore) watchAdded(canxCtx context.Context,
+	requestCanxCtx context.Context,
+	errorStream chan error,
+	match func(request utils.Request) bool) chan utils.Request {
+	requestsChan := make(chan utils.Request)
+	matched := map[string]bool{}
+
+	go func() {
+		defer close(requestsChan)
+
+		watchCtx := watchContext(canxCtx, requestCanxCtx)
+		s.watch(watchCtx, func() []utils.Request {
+			added := []utils.Request{}
+			for _, id := range s.order {
+				request := s.requests[id]
+				matches := match(request)
+
+				if matches && !matched[id] {
+					added = append(added, request)
+				}
+				matched[id] = matches
+			}
+			return added
+		}, func(request utils.Request) bool {
+			select {
+			case <-watchCtx.Done():
+				return false
+			case requestsChan <- request:
+				return true
+			}
+		})
+
+		if canxCtx.Err() != nil {
+			errorStream <- fmt.Errorf("watchRequests context cancelled: %v", canxCtx.Err())
+			return
+		}
+
+		if requestCanxCtx != nil && requestCanxCtx.Err() != nil {
+			errorStream <- fmt.Errorf("watchRequests parent context cancelled: %v", requestCanxCtx.Err())
+			return
+		}
+	}()
+
+	return requestsChan
+}
+
+func (s *memoryStore) WatchRequest(canxCtx context.Context,
+	requestCanxCtx context.Context,
+	_ chan error,
+	id string) chan utils.Request {
+	requestChan := make(chan utils.Request)
+	version := 0
+
+	go func() {
+		defer close(requestChan)
+
+		watchCtx := watchContext(canxCtx, requestCanxCtx)
+		s.watch(watchCtx, func() []utils.Request {
+			request, ok := s.requests[id]
+			if !ok || s.versions[id] == version {
+				return nil
+			}
+
+			version = s.versions[id]
+			return []utils.Request{request}
+		}, func(request utils.Request) bool {
+			select {
+			case <-watchCtx.Done():
+				return false
+			case requestChan <- request:
+				return true
+			}
+		})
+	}()
+
+	return requestChan
+}
+
+func (s *memoryStore) WatchAbort(canxCtx context.Context,
+	requestCanxCtx context.Context,
+	_ chan error,
+	id string) chan struct{} {
+	abortChan := make(chan struct{})
+
+	go func() {
+		defer close(abortChan)
+
+		watchCtx := watchContext(canxCtx, requestCanxCtx)
+		s.watch(watchCtx, func() []utils.Request {
+			request, ok := s.requests[id]
+			if !ok || !request.Abort {
+				return nil
+			}
+
+			return []utils.Request{request}
+		}, func(_ utils.Request) bool {
+			lgr.Logger.Info("abortWatcher detected an abort",
+				slog.String("request", id),
+			)
+			select {
+			case <-watchCtx.Done():
+			case abortChan <- struct{}{}:
+			}
+			return false
+		})
+	}()
+
+	return abortChan
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+// watch evaluates the changes every time the store is written to and emits them
+// until the context is cancelled or emit returns false. Changes is called while
+// holding the store lock.
+func (s *memoryStore) watch(watchCtx context.Context,
+	changes func() []utils.Request,
+	emit func(request utils.Request) bool) {
+	signal := make(chan struct{}, 1)
+	// Force an initial evaluation just like a Firestore snapshot
+	signal <- struct{}{}
+
+	s.mutex.Lock()
+	s.signals[signal] = struct{}{}
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		delete(s.signals, signal)
+		s.mutex.Unlock()
+	}()
+
+	for {
+		select {
+		case <-watchCtx.Done():
+			return
+		case <-signal:
+			s.mutex.Lock()
+			requests := changes()
+			s.mutex.Unlock()
+
+			for _, request := range requests {
+				if !emit(request) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// notify wakes up all watchers. It must be called while holding the store lock.
+func (s *memoryStore) notify() {
+	for signal := range s.signals {
+		select {
+		case signal <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// newID generates a random ID similar in length to Firestore's auto IDs
+func newID() (string, error) {
+	b := make([]byte, 10)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
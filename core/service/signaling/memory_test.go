@@ -0,0 +1,393 @@
+package signaling
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/khaledhikmat/family-meeting/utils"
+)
+
+const testTimeout = 2 * time.Second
+
+func newTestStore(t *testing.T) SignalingStore {
+	t.Helper()
+
+	store, err := NewMemory(context.Background())
+	if err != nil {
+		t.Fatalf("NewMemory error: %v", err)
+	}
+
+	return store
+}
+
+func createRequest(t *testing.T, store SignalingStore, request utils.Request) string {
+	t.Helper()
+
+	id, err := store.CreateRequest(context.Background(), request)
+	if err != nil {
+		t.Fatalf("CreateRequest error: %v", err)
+	}
+
+	return id
+}
+
+func updateRequest(t *testing.T, store SignalingStore, id string, fields map[string]interface{}) {
+	t.Helper()
+
+	err := store.UpdateRequest(context.Background(), id, fields)
+	if err != nil {
+		t.Fatalf("UpdateRequest error: %v", err)
+	}
+}
+
+// drainErrors keeps the watchers from blocking on the error stream
+func drainErrors(canxCtx context.Context) chan error {
+	errorStream := make(chan error)
+	go func() {
+		for {
+			select {
+			case <-canxCtx.Done():
+				return
+			case <-errorStream:
+			}
+		}
+	}()
+
+	return errorStream
+}
+
+// receiveUntil returns the IDs of the requests received until the sentinel request
+func receiveUntil(t *testing.T, requests chan utils.Request, sentinel string) []string {
+	t.Helper()
+
+	ids := []string{}
+	timer := time.NewTimer(testTimeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			t.Fatalf("sentinel %s not received. Received %v", sentinel, ids)
+		case request, ok := <-requests:
+			if !ok {
+				t.Fatalf("watch closed before the sentinel %s. Received %v", sentinel, ids)
+			}
+
+			if request.ID == sentinel {
+				return ids
+			}
+			ids = append(ids, request.ID)
+		}
+	}
+}
+
+func TestMemoryWatchRequests(t *testing.T) {
+	offer := utils.Request{
+		Kind:  "broadcaster",
+		Offer: "offer",
+	}
+
+	tests := []struct {
+		name    string
+		request utils.Request
+		// Applied once the watch started
+		updates []map[string]interface{}
+		added   bool
+	}{
+		{
+			name:    "offer",
+			request: offer,
+			added:   true,
+		},
+		{
+			name:    "other kind",
+			request: utils.Request{Kind: "participant", Offer: "offer"},
+		},
+		{
+			name:    "other parent",
+			request: utils.Request{Kind: "broadcaster", Parent: "parent", Offer: "offer"},
+		},
+		{
+			name:    "no offer",
+			request: utils.Request{Kind: "broadcaster"},
+		},
+		{
+			name:    "answered",
+			request: utils.Request{Kind: "broadcaster", Offer: "offer", Answer: "answer"},
+		},
+		{
+			name:    "aborted",
+			request: utils.Request{Kind: "broadcaster", Offer: "offer", Abort: true},
+		},
+		{
+			name:    "offer set later",
+			request: utils.Request{Kind: "broadcaster"},
+			updates: []map[string]interface{}{
+				{"offer": "offer"},
+			},
+			added: true,
+		},
+		{
+			name:    "added once",
+			request: offer,
+			updates: []map[string]interface{}{
+				{"record": true},
+				{"status": utils.StatusDispatched},
+			},
+			added: true,
+		},
+		{
+			name:    "aborted later",
+			request: utils.Request{Kind: "broadcaster"},
+			updates: []map[string]interface{}{
+				{"abort": true},
+				{"offer": "offer"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			canxCtx, canxFn := context.WithCancel(context.Background())
+			defer canxFn()
+
+			store := newTestStore(t)
+			id := createRequest(t, store, test.request)
+			requests := store.WatchRequests(canxCtx, nil, drainErrors(canxCtx), "broadcaster", "")
+
+			for _, fields := range test.updates {
+				updateRequest(t, store, id, fields)
+			}
+
+			// The sentinel is added after the request so the request is evaluated first
+			sentinel := createRequest(t, store, offer)
+			ids := receiveUntil(t, requests, sentinel)
+
+			want := []string{}
+			if test.added {
+				want = append(want, id)
+			}
+
+			if len(ids) != len(want) || (len(want) > 0 && ids[0] != want[0]) {
+				t.Fatalf("got %v, want %v", ids, want)
+			}
+		})
+	}
+}
+
+func TestMemoryWatchSourceRequests(t *testing.T) {
+	canxCtx, canxFn := context.WithCancel(context.Background())
+	defer canxFn()
+
+	store := newTestStore(t)
+	camera := createRequest(t, store, utils.Request{Kind: "rtsp", URL: "rtsp://camera/stream"})
+	createRequest(t, store, utils.Request{Kind: "rtsp"})
+	createRequest(t, store, utils.Request{Kind: "rtsp", URL: "rtsp://camera/stream", Answer: "answer"})
+	createRequest(t, store, utils.Request{Kind: "rtmp", URL: "rtsp://camera/stream"})
+
+	requests := store.WatchSourceRequests(canxCtx, nil, drainErrors(canxCtx), "rtsp")
+	sentinel := createRequest(t, store, utils.Request{Kind: "rtsp", URL: "rtsp://camera/sentinel"})
+
+	ids := receiveUntil(t, requests, sentinel)
+	if len(ids) != 1 || ids[0] != camera {
+		t.Fatalf("got %v, want [%s]", ids, camera)
+	}
+}
+
+func TestMemoryUpdateRequest(t *testing.T) {
+	store := newTestStore(t)
+	id := createRequest(t, store, utils.Request{
+		Kind:  "broadcaster",
+		Offer: "offer",
+	})
+
+	updateRequest(t, store, id, map[string]interface{}{
+		"abort":     true,
+		"record":    true,
+		"rejection": "meeting full",
+	})
+
+	request, err := store.GetRequest(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetRequest error: %v", err)
+	}
+
+	if request.ID != id || request.Kind != "broadcaster" || request.Offer != "offer" {
+		t.Fatalf("unexpected request %+v", request)
+	}
+
+	if !request.Abort || !request.Record || request.Rejection != "meeting full" {
+		t.Fatalf("fields not updated %+v", request)
+	}
+
+	err = store.UpdateRequest(context.Background(), "missing", map[string]interface{}{"abort": true})
+	if err == nil {
+		t.Fatalf("UpdateRequest of a missing request succeeded")
+	}
+}
+
+func TestMemoryWatchRequest(t *testing.T) {
+	canxCtx, canxFn := context.WithCancel(context.Background())
+	defer canxFn()
+
+	store := newTestStore(t)
+	id := createRequest(t, store, utils.Request{Kind: "broadcaster"})
+	requests := store.WatchRequest(canxCtx, nil, drainErrors(canxCtx), id)
+
+	receive := func() utils.Request {
+		t.Helper()
+
+		select {
+		case <-time.After(testTimeout):
+			t.Fatalf("request not received")
+		case request := <-requests:
+			return request
+		}
+		return utils.Request{}
+	}
+
+	// The current version is received first like a Firestore snapshot
+	if request := receive(); request.Record {
+		t.Fatalf("got %+v, want the current request", request)
+	}
+
+	updateRequest(t, store, id, map[string]interface{}{"record": true})
+	if request := receive(); !request.Record {
+		t.Fatalf("got %+v, want the updated request", request)
+	}
+
+	canxFn()
+	select {
+	case <-time.After(testTimeout):
+		t.Fatalf("watch not closed on cancel")
+	case _, ok := <-requests:
+		if ok {
+			t.Fatalf("request received after cancel")
+		}
+	}
+}
+
+func TestMemoryWatchAbort(t *testing.T) {
+	tests := []struct {
+		name    string
+		aborted bool
+		// The abort is set before the watch starts
+		before bool
+	}{
+		{
+			name:    "aborted",
+			aborted: true,
+		},
+		{
+			name:    "aborted before the watch",
+			aborted: true,
+			before:  true,
+		},
+		{
+			name: "cancelled",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			canxCtx, canxFn := context.WithCancel(context.Background())
+			defer canxFn()
+
+			requestCanxCtx, requestCanxFn := context.WithCancel(canxCtx)
+			defer requestCanxFn()
+
+			store := newTestStore(t)
+			id := createRequest(t, store, utils.Request{Kind: "broadcaster"})
+			if test.before {
+				updateRequest(t, store, id, map[string]interface{}{"abort": true})
+			}
+
+			abort := store.WatchAbort(canxCtx, requestCanxCtx, drainErrors(canxCtx), id)
+			switch {
+			case test.aborted && !test.before:
+				updateRequest(t, store, id, map[string]interface{}{"abort": true})
+			case !test.aborted:
+				requestCanxFn()
+			}
+
+			select {
+			case <-time.After(testTimeout):
+				t.Fatalf("abort watch neither fired nor closed")
+			case _, ok := <-abort:
+				if ok != test.aborted {
+					t.Fatalf("got abort %v, want %v", ok, test.aborted)
+				}
+			}
+		})
+	}
+}
+
+func TestMemorySetStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []string
+		allowed  bool
+	}{
+		{
+			name:     "lifecycle",
+			statuses: []string{utils.StatusRequested, utils.StatusDispatched, utils.StatusNegotiating, utils.StatusLive, utils.StatusEnded},
+			allowed:  true,
+		},
+		{
+			name:     "failed while negotiating",
+			statuses: []string{utils.StatusRequested, utils.StatusNegotiating, utils.StatusFailed},
+			allowed:  true,
+		},
+		{
+			name:     "live before negotiating",
+			statuses: []string{utils.StatusRequested, utils.StatusLive},
+		},
+		{
+			name:     "ended is final",
+			statuses: []string{utils.StatusRequested, utils.StatusEnded, utils.StatusFailed},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := newTestStore(t)
+			id := createRequest(t, store, utils.Request{Kind: "broadcaster"})
+
+			var err error
+			for _, status := range test.statuses {
+				err = store.SetStatus(context.Background(), id, NewTransition(status, "reason "+status))
+				if err != nil {
+					break
+				}
+			}
+
+			if test.allowed != (err == nil) {
+				t.Fatalf("got error %v, want allowed %v", err, test.allowed)
+			}
+
+			if err != nil && !errors.Is(err, ErrStatusTransition) {
+				t.Fatalf("got error %v, want %v", err, ErrStatusTransition)
+			}
+
+			request, err := store.GetRequest(context.Background(), id)
+			if err != nil {
+				t.Fatalf("GetRequest error: %v", err)
+			}
+
+			if !test.allowed {
+				return
+			}
+
+			last := test.statuses[len(test.statuses)-1]
+			if request.Status != last || len(request.Transitions) != len(test.statuses) {
+				t.Fatalf("got status %s with %d transitions, want %s with %d", request.Status, len(request.Transitions), last, len(test.statuses))
+			}
+
+			if last == utils.StatusFailed && request.FailureReason != "reason "+last {
+				t.Fatalf("got failure reason %q", request.FailureReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package signaling
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/khaledhikmat/family-meeting/utils"
+)
+
+// WaitForOffer blocks until an offer is made on the request or the context(s) are cancelled
+func WaitForOffer(canxCtx context.Context,
+	requestCanxCtx context.Context,
+	errorStream chan error,
+	store SignalingStore,
+	id string) webrtc.SessionDescription {
+	// Stop watching the request as soon as we have an offer
+	offerCanxCtx, offerCanxFn := context.WithCancel(watchContext(canxCtx, requestCanxCtx))
+	defer offerCanxFn()
+
+	requestStream := store.WatchRequest(canxCtx, offerCanxCtx, errorStream, id)
+
+	for {
+		select {
+		case <-canxCtx.Done():
+			errorStream <- fmt.Errorf("waitForOffer context cancelled: %v", canxCtx.Err())
+			return webrtc.SessionDescription{}
+		case <-offerCanxCtx.Done():
+			errorStream <- fmt.Errorf("waitForOffer parent context cancelled: %v", offerCanxCtx.Err())
+			return webrtc.SessionDescription{}
+		case request, ok := <-requestStream:
+			if !ok {
+				errorStream <- fmt.Errorf("waitForOffer request stream closed")
+				return webrtc.SessionDescription{}
+			}
+
+			if request.Offer == "" {
+				continue
+			}
+
+			offer := webrtc.SessionDescription{}
+			utils.Decode(request.Offer, &offer)
+			return offer
+		}
+	}
+}
+
+// WaitForAnswer blocks until the request is answered or the context(s) are cancelled
+func WaitForAnswer(canxCtx context.Context,
+	requestCanxCtx context.Context,
+	errorStream chan error,
+	store SignalingStore,
+	id string) webrtc.SessionDescription {
+	// Stop watching the request as soon as we have an answer
+	answerCanxCtx, answerCanxFn := context.WithCancel(watchContext(canxCtx, requestCanxCtx))
+	defer answerCanxFn()
+
+	requestStream := store.WatchRequest(canxCtx, answerCanxCtx, errorStream, id)
+
+	for {
+		select {
+		case <-canxCtx.Done():
+			errorStream <- fmt.Errorf("waitForAnswer context cancelled: %v", canxCtx.Err())
+			return webrtc.SessionDescription{}
+		case <-answerCanxCtx.Done():
+			errorStream <- fmt.Errorf("waitForAnswer parent context cancelled: %v", answerCanxCtx.Err())
+			return webrtc.SessionDescription{}
+		case request, ok := <-requestStream:
+			if !ok {
+				errorStream <- fmt.Errorf("waitForAnswer request stream closed")
+				return webrtc.SessionDescription{}
+			}
+
+			if request.Answer == "" {
+				continue
+			}
+
+			answer := webrtc.SessionDescription{}
+			utils.Decode(request.Answer, &answer)
+			return answer
+		}
+	}
+}
+
+// WatchCandidates streams the ICE candidates trickled on the request candidates field
+// i.e. `offerCandidates` or `answerCandidates`. The stream is closed when the context(s) are cancelled.
+func WatchCandidates(canxCtx context.Context,
+	requestCanxCtx context.Context,
+	errorStream chan error,
+	store SignalingStore,
+	id string,
+	field string) chan webrtc.ICECandidateInit {
+	candidatesChan := make(chan webrtc.ICECandidateInit)
+
+	go func() {
+		defer close(candidatesChan)
+
+		watchCtx := watchContext(canxCtx, requestCanxCtx)
+		applied := 0
+		for request := range store.WatchRequest(canxCtx, requestCanxCtx, errorStream, id) {
+			candidates := request.OfferCandidates
+			if field == utils.AnswerCandidates {
+				candidates = request.AnswerCandidates
+			}
+
+			for ; applied < len(candidates); applied++ {
+				candidate := webrtc.ICECandidateInit{}
+				err := utils.DecodeCandidate(candidates[applied], &candidate)
+				if err != nil {
+					errorStream <- fmt.Errorf("watchCandidates error decoding candidate: %v", err)
+					continue
+				}
+
+				select {
+				case <-watchCtx.Done():
+					return
+				case candidatesChan <- candidate:
+				}
+			}
+		}
+	}()
+
+	return candidatesChan
+}
+
+// watchContext returns the request context if provided. Otherwise it returns the main context.
+func watchContext(canxCtx context.Context, requestCanxCtx context.Context) context.Context {
+	if requestCanxCtx != nil {
+		return requestCanxCtx
+	}
+
+	return canxCtx
+}
@@ -0,0 +1,86 @@
+package signaling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/khaledhikmat/family-meeting/service/lgr"
+	"github.com/khaledhikmat/family-meeting/utils"
+)
+
+const (
+	instanceIDKey = "INSTANCE_ID"
+	// Statuses are written even when the instance is shutting down so they get a bit of time
+	statusTimeout = 3 * time.Second
+)
+
+// ErrStatusTransition is returned when the request lifecycle does not allow the status
+var ErrStatusTransition = errors.New("status transition not allowed")
+
+var instanceID = newInstanceID()
+
+// The instance defaults to the host name i.e. the pod name
+func newInstanceID() string {
+	if os.Getenv(instanceIDKey) != "" {
+		return os.Getenv(instanceIDKey)
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+
+	return host
+}
+
+// InstanceID identifies this instance in the request transitions
+func InstanceID() string {
+	return instanceID
+}
+
+// NewTransition is a transition made now by this instance i.e. the initial status of a request
+func NewTransition(status string, reason string) utils.Transition {
+	return utils.Transition{
+		Status:   status,
+		At:       time.Now().UTC(),
+		Instance: instanceID,
+		Reason:   reason,
+	}
+}
+
+// SetStatus moves the request to the status on behalf of this instance. The reason explains
+// a failure or an end. A transition the lifecycle does not allow (i.e. the request already
+// ended) is skipped. The status is written even if the context(s) are cancelled.
+func SetStatus(canxCtx context.Context,
+	errorStream chan error,
+	store SignalingStore,
+	id string,
+	status string,
+	reason string) {
+	statusCtx, statusCanxFn := context.WithTimeout(context.WithoutCancel(canxCtx), statusTimeout)
+	defer statusCanxFn()
+
+	err := store.SetStatus(statusCtx, id, NewTransition(status, reason))
+	if errors.Is(err, ErrStatusTransition) {
+		lgr.Logger.InfoContext(canxCtx, "SetStatus skipped",
+			slog.String("request", id),
+			slog.String("reason", err.Error()),
+		)
+		return
+	}
+
+	if err != nil {
+		errorStream <- fmt.Errorf("SetStatus store.SetStatus error: %v", err)
+		return
+	}
+
+	lgr.Logger.InfoContext(canxCtx, "SetStatus request moved",
+		slog.String("request", id),
+		slog.String("status", status),
+		slog.String("reason", reason),
+	)
+}
@@ -0,0 +1,68 @@
+package signaling
+
+import (
+	"context"
+
+	"github.com/khaledhikmat/family-meeting/utils"
+)
+
+// SignalingStore abstracts the backend used to exchange broadcast and participant
+// requests (offers, answers and aborts) between the web clients and the core.
+type SignalingStore interface {
+	// CreateRequest stores a new request and returns its ID
+	CreateRequest(canxCtx context.Context, request utils.Request) (string, error)
+
+	// GetRequest retrieves a request by ID
+	GetRequest(canxCtx context.Context, id string) (utils.Request, error)
+
+	// UpdateRequest updates the request fields. Fields are keyed by their stored names
+	// i.e. `answer`, `abort`, etc.
+	UpdateRequest(canxCtx context.Context, id string, fields map[string]interface{}) error
+
+	// SetAnswer updates the request answer
+	SetAnswer(canxCtx context.Context, id string, answer string) error
+
+	// SetStatus moves the request to the transition status and appends the transition to the
+	// request transitions. It returns ErrStatusTransition if the lifecycle does not allow it.
+	SetStatus(canxCtx context.Context, id string, transition utils.Transition) error
+
+	// AddCandidate appends a trickled ICE candidate to the request candidates field
+	// i.e. `offerCandidates` or `answerCandidates`
+	AddCandidate(canxCtx context.Context, id string, field string, candidate string) error
+
+	// CreateRecording stores the metadata of a recording and returns its ID
+	CreateRecording(canxCtx context.Context, recording utils.Recording) (string, error)
+
+	// WatchRequests streams requests of a certain kind and parent as soon as they have an offer
+	// but no answer and are not aborted. The stream is closed when the context(s) are cancelled.
+	WatchRequests(canxCtx context.Context,
+		requestCanxCtx context.Context,
+		errorStream chan error,
+		kind string,
+		parent string) chan utils.Request
+
+	// WatchSourceRequests streams requests of a certain kind as soon as they have a source URL
+	// (i.e. an RTSP camera) but no answer and are not aborted. The stream is closed when the
+	// context(s) are cancelled.
+	WatchSourceRequests(canxCtx context.Context,
+		requestCanxCtx context.Context,
+		errorStream chan error,
+		kind string) chan utils.Request
+
+	// WatchRequest streams every change made to a request. The stream is closed when the
+	// context(s) are cancelled.
+	WatchRequest(canxCtx context.Context,
+		requestCanxCtx context.Context,
+		errorStream chan error,
+		id string) chan utils.Request
+
+	// WatchAbort emits once when the request is aborted. The stream is closed when the
+	// context(s) are cancelled.
+	WatchAbort(canxCtx context.Context,
+		requestCanxCtx context.Context,
+		errorStream chan error,
+		id string) chan struct{}
+
+	// Close releases the store resources
+	Close() error
+}
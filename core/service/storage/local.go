@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	defaultLocalDir = "archive"
+)
+
+type localSink struct {
+	dir string
+}
+
+// NewLocal creates a sink that moves recordings to a local directory. The directory
+// is taken from RECORDING_SINK_DIR.
+func NewLocal(_ context.Context) (RecordingSink, error) {
+	dir := defaultLocalDir
+	if os.Getenv("RECORDING_SINK_DIR") != "" {
+		dir = os.Getenv("RECORDING_SINK_DIR")
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("resolving local sink dir %s: %v", dir, err)
+	}
+
+	return &localSink{
+		dir: dir,
+	}, nil
+}
+
+func (s *localSink) Save(_ context.Context, key string, path string) (string, error) {
+	dest := filepath.Join(s.dir, filepath.FromSlash(key))
+	err := os.MkdirAll(filepath.Dir(dest), 0o755)
+	if err != nil {
+		return "", err
+	}
+
+	// Rename fails across devices (i.e. mounted volumes) so fall back to copying
+	err = os.Rename(path, dest)
+	if err != nil {
+		err = copyFile(path, dest)
+		if err != nil {
+			return "", err
+		}
+
+		err = os.Remove(path)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return "file://" + filepath.ToSlash(dest), nil
+}
+
+func (s *localSink) Close() error {
+	return nil
+}
+
+func copyFile(src string, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+Reference:
+https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+The S3 API is also served by MinIO and by GCS (using HMAC keys) so one sink covers all of them.
+*/
+const (
+	defaultS3Endpoint = "https://s3.amazonaws.com"
+	defaultS3Region   = "us-east-1"
+	s3Service         = "s3"
+	s3Algorithm       = "AWS4-HMAC-SHA256"
+	s3DateFormat      = "20060102T150405Z"
+)
+
+type s3Sink struct {
+	client    *http.Client
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+}
+
+// NewS3 creates a sink that uploads recordings to an S3-compatible bucket. It is configured
+// using S3_ENDPOINT, S3_REGION, S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY.
+func NewS3(_ context.Context) (RecordingSink, error) {
+	endpoint := defaultS3Endpoint
+	if os.Getenv("S3_ENDPOINT") != "" {
+		endpoint = os.Getenv("S3_ENDPOINT")
+	}
+
+	region := defaultS3Region
+	if os.Getenv("S3_REGION") != "" {
+		region = os.Getenv("S3_REGION")
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parsing s3 endpoint %s: %v", endpoint, err)
+	}
+
+	sink := &s3Sink{
+		client:    &http.Client{},
+		endpoint:  u,
+		bucket:    os.Getenv("S3_BUCKET"),
+		region:    region,
+		accessKey: os.Getenv("S3_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+	}
+
+	if sink.bucket == "" || sink.accessKey == "" || sink.secretKey == "" {
+		return nil, fmt.Errorf("creating s3 sink: S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY are required")
+	}
+
+	return sink, nil
+}
+
+func (s *s3Sink) Save(canxCtx context.Context, key string, path string) (string, error) {
+	payloadHash, err := fileHash(path)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	// Path-style URLs work with every S3-compatible endpoint
+	u := *s.endpoint
+	u.Path = "/" + s.bucket + "/" + key
+
+	req, err := http.NewRequestWithContext(canxCtx, http.MethodPut, u.String(), f)
+	if err != nil {
+		return "", err
+	}
+
+	req.ContentLength = info.Size()
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	req.Header.Set("x-amz-date", time.Now().UTC().Format(s3DateFormat))
+	s.sign(req, payloadHash)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("uploading %s: status %d: %s", key, resp.StatusCode, string(b))
+	}
+
+	// The upload succeeded so the local file is no longer needed
+	f.Close()
+	err = os.Remove(path)
+	if err != nil {
+		return "", err
+	}
+
+	return "s3://" + s.bucket + "/" + key, nil
+}
+
+func (s *s3Sink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// sign adds the SigV4 authorization header. The host and the request headers
+// (i.e. `x-amz-date`) are signed.
+func (s *s3Sink) sign(req *http.Request, payloadHash string) {
+	amzDate := req.Header.Get("x-amz-date")
+	date := amzDate[:8]
+	scope := strings.Join([]string{date, s.region, s3Service, "aws4_request"}, "/")
+
+	headers := map[string]string{
+		"host": req.URL.Host,
+	}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		amzDate,
+		scope,
+		hashHex([]byte(canonicalRequest)),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	signingKey = hmacSHA256(signingKey, s.region)
+	signingKey = hmacSHA256(signingKey, s3Service)
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.accessKey, scope, signedHeaders, signature))
+}
+
+func fileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashHex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
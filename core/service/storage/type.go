@@ -0,0 +1,15 @@
+package storage
+
+import (
+	"context"
+)
+
+// RecordingSink abstracts where finalized broadcast recordings are kept
+type RecordingSink interface {
+	// Save moves the local file to the sink under the key and returns its location
+	// i.e. `file:///...` or `s3://bucket/key`. The local file is removed on success.
+	Save(canxCtx context.Context, key string, path string) (string, error)
+
+	// Close releases the sink resources
+	Close() error
+}
@@ -1,119 +1,94 @@
 package utils
 
 import (
-	"context"
 	"encoding/base64"
 	"encoding/json"
-	"fmt"
+	"time"
 
-	"cloud.google.com/go/firestore"
 	"github.com/pion/webrtc/v4"
 )
 
-type Request struct {
-	ID        string `json:"id"`
-	Parent    string `json:"parent"`
-	Requestor string `json:"requestor"`
-	Kind      string `json:"kind"`
-	Offer     string `json:"offer"`
-	Answer    string `json:"answer"`
-	Abort     bool   `json:"abort"`
+// Request candidate fields
+const (
+	OfferCandidates  = "offerCandidates"
+	AnswerCandidates = "answerCandidates"
+)
+
+// Broadcast lifecycle statuses
+// requested -> dispatched -> negotiating -> live -> ended/failed
+const (
+	StatusRequested   = "requested"
+	StatusDispatched  = "dispatched"
+	StatusNegotiating = "negotiating"
+	StatusLive        = "live"
+	StatusEnded       = "ended"
+	StatusFailed      = "failed"
+)
+
+// statusTransitions lists the statuses each status can move to. Broadcasts created by the
+// core (i.e. WHIP or RTMP) are not dispatched. Requests created by older clients have no status.
+var statusTransitions = map[string][]string{
+	"":                {StatusRequested, StatusDispatched, StatusNegotiating, StatusFailed, StatusEnded},
+	StatusRequested:   {StatusDispatched, StatusNegotiating, StatusFailed, StatusEnded},
+	StatusDispatched:  {StatusNegotiating, StatusFailed, StatusEnded},
+	StatusNegotiating: {StatusLive, StatusFailed, StatusEnded},
+	StatusLive:        {StatusEnded, StatusFailed},
 }
 
-func MonitorRequests(canxCtx context.Context,
-	requestCanxCtx context.Context,
-	_ context.CancelFunc,
-	errorStream chan error,
-	db *firestore.Client,
-	kind string,
-	parent string) chan *firestore.DocumentSnapshot {
-	requestsChan := make(chan *firestore.DocumentSnapshot)
-
-	go func() {
-		defer close(requestsChan)
-
-		reqRef := db.Collection("broadcast_requests").
-			Where("kind", "==", kind).
-			Where("parent", "==", parent).
-			Where("offer", "!=", "").
-			Where("answer", "==", "").
-			Where("abort", "==", false)
-		//q := reqRef.OrderBy("createdAt", firestore.Desc).Limit(3)
-
-		iter := reqRef.Snapshots(canxCtx)
-		defer iter.Stop()
-
-		for {
-			if canxCtx.Err() != nil {
-				errorStream <- fmt.Errorf("monitorRequests context cancelled: %v", canxCtx.Err())
-				return
-			}
-
-			if requestCanxCtx != nil && requestCanxCtx.Err() != nil {
-				errorStream <- fmt.Errorf("monitorRequests parent context cancelled: %v", requestCanxCtx.Err())
-				return
-			}
-
-			// TODO: This is a blocking atomic operation!!!!
-			// While waiting, we are not checking for context cancellation
-			snap, err := iter.Next()
-			if err != nil {
-				errorStream <- fmt.Errorf("monitorRequests error getting snapshot: %v", err)
-				continue
-			}
-			for _, change := range snap.Changes {
-				if change.Kind == firestore.DocumentAdded {
-					requestsChan <- change.Doc
-				}
-			}
+// CanTransition tells whether a request can move from one status to another.
+// Ended and failed are final.
+func CanTransition(from string, to string) bool {
+	for _, status := range statusTransitions[from] {
+		if status == to {
+			return true
 		}
-	}()
+	}
 
-	return requestsChan
+	return false
 }
 
-func WaitForOffer(canxCtx context.Context,
-	requestCanxCtx context.Context,
-	errorStream chan error,
-	_ *firestore.Client,
-	reqDoc *firestore.DocumentRef) webrtc.SessionDescription {
-	// Wait until an offer is made by a requestor (kind)
-	iter := reqDoc.Snapshots(canxCtx)
-	defer iter.Stop()
-
-	for {
-		if canxCtx.Err() != nil {
-			errorStream <- fmt.Errorf("waitForOffer context cancelled: %v", canxCtx.Err())
-			return webrtc.SessionDescription{}
-		}
+type Request struct {
+	ID        string `json:"id" firestore:"-"`
+	Parent    string `json:"parent" firestore:"parent"`
+	Requestor string `json:"requestor" firestore:"requestor"`
+	Kind      string `json:"kind" firestore:"kind"`
+	Offer     string `json:"offer" firestore:"offer"`
+	Answer    string `json:"answer" firestore:"answer"`
+	Abort     bool   `json:"abort" firestore:"abort"`
+	// Trickled ICE candidates from the offerer and the answerer
+	OfferCandidates  []string `json:"offerCandidates" firestore:"offerCandidates"`
+	AnswerCandidates []string `json:"answerCandidates" firestore:"answerCandidates"`
+	// Records the broadcast while true
+	Record bool `json:"record" firestore:"record"`
+	// Source of broadcasts pulled by the core i.e. an RTSP camera URL
+	URL string `json:"url" firestore:"url"`
+	// Why the core did not answer or removed the participant i.e. the meeting is full
+	Rejection string `json:"rejection" firestore:"rejection"`
+	// Broadcast lifecycle i.e. `live`. The instance is the one that last moved it.
+	Status        string       `json:"status" firestore:"status"`
+	Instance      string       `json:"instance" firestore:"instance"`
+	FailureReason string       `json:"failureReason" firestore:"failureReason"`
+	Transitions   []Transition `json:"transitions" firestore:"transitions"`
+}
 
-		if requestCanxCtx != nil && requestCanxCtx.Err() != nil {
-			errorStream <- fmt.Errorf("waitForOffer parent context cancelled: %v", requestCanxCtx.Err())
-			return webrtc.SessionDescription{}
-		}
+// Transition records when a request moved to a status and by which instance
+type Transition struct {
+	Status   string    `json:"status" firestore:"status"`
+	At       time.Time `json:"at" firestore:"at"`
+	Instance string    `json:"instance" firestore:"instance"`
+	Reason   string    `json:"reason" firestore:"reason"`
+}
 
-		// TODO: This is a blocking atomic operation!!!!
-		// While waiting, we are not checking for context cancellation
-		snap, err := iter.Next()
-		if err != nil {
-			errorStream <- fmt.Errorf("waitForOffer error getting snapshot: %v", err)
-			continue
-		}
-		if snap.Exists() {
-			request := Request{}
-			err = snap.DataTo(&request)
-			if err != nil {
-				errorStream <- fmt.Errorf("waitForOffer error getting request data: %v", err)
-				continue
-			}
-			if request.Offer == "" {
-				continue
-			}
-			offer := webrtc.SessionDescription{}
-			Decode(request.Offer, &offer)
-			return offer
-		}
-	}
+// Recording links a recorded broadcast track to its request
+type Recording struct {
+	ID          string    `json:"id" firestore:"-"`
+	BroadcastID string    `json:"broadcastId" firestore:"broadcastId"`
+	Kind        string    `json:"kind" firestore:"kind"`
+	Codec       string    `json:"codec" firestore:"codec"`
+	Location    string    `json:"location" firestore:"location"`
+	Size        int64     `json:"size" firestore:"size"`
+	StartedAt   time.Time `json:"startedAt" firestore:"startedAt"`
+	EndedAt     time.Time `json:"endedAt" firestore:"endedAt"`
 }
 
 // JSON encode + base64 a SessionDescription
@@ -137,3 +112,23 @@ func Decode(in string, obj *webrtc.SessionDescription) {
 		panic(err)
 	}
 }
+
+// JSON encode + base64 an ICECandidateInit
+func EncodeCandidate(obj *webrtc.ICECandidateInit) string {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		panic(err)
+	}
+
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// Decode a base64 and unmarshal JSON into an ICECandidateInit
+func DecodeCandidate(in string, obj *webrtc.ICECandidateInit) error {
+	b, err := base64.StdEncoding.DecodeString(in)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, obj)
+}
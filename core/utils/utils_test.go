@@ -0,0 +1,42 @@
+package utils
+
+import "testing"
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from    string
+		to      string
+		allowed bool
+	}{
+		{from: "", to: StatusRequested, allowed: true},
+		{from: "", to: StatusNegotiating, allowed: true},
+		{from: "", to: StatusLive},
+		{from: StatusRequested, to: StatusDispatched, allowed: true},
+		{from: StatusRequested, to: StatusNegotiating, allowed: true},
+		{from: StatusRequested, to: StatusLive},
+		{from: StatusRequested, to: StatusRequested},
+		{from: StatusDispatched, to: StatusNegotiating, allowed: true},
+		{from: StatusDispatched, to: StatusRequested},
+		{from: StatusDispatched, to: StatusDispatched},
+		{from: StatusNegotiating, to: StatusLive, allowed: true},
+		{from: StatusNegotiating, to: StatusFailed, allowed: true},
+		{from: StatusNegotiating, to: StatusDispatched},
+		{from: StatusLive, to: StatusEnded, allowed: true},
+		{from: StatusLive, to: StatusFailed, allowed: true},
+		{from: StatusLive, to: StatusNegotiating},
+		{from: StatusEnded, to: StatusFailed},
+		{from: StatusEnded, to: StatusLive},
+		{from: StatusFailed, to: StatusEnded},
+		{from: StatusFailed, to: StatusRequested},
+		{from: StatusLive, to: "unknown"},
+		{from: "unknown", to: StatusEnded},
+	}
+
+	for _, test := range tests {
+		t.Run(test.from+"->"+test.to, func(t *testing.T) {
+			if got := CanTransition(test.from, test.to); got != test.allowed {
+				t.Fatalf("CanTransition(%q, %q) = %v, want %v", test.from, test.to, got, test.allowed)
+			}
+		})
+	}
+}
@@ -2,7 +2,7 @@ import './style.css'
 // Firebase imports
 import { initializeApp } from 'firebase/app'
 import { getAuth, signOut, signInWithPopup, GoogleAuthProvider } from "firebase/auth";
-import { getFirestore, serverTimestamp, collection, doc, addDoc, setDoc, getDoc, getDocs, updateDoc, query, orderBy, limit, onSnapshot  } from "firebase/firestore";
+import { getFirestore, serverTimestamp, collection, doc, addDoc, setDoc, getDoc, getDocs, updateDoc, query, orderBy, limit, onSnapshot, arrayUnion  } from "firebase/firestore";
 
 // Firebase configuration
 const firebaseConfig = {
@@ -56,8 +56,8 @@ auth.onAuthStateChanged(user => {
 const db = getFirestore(app);
 
 // WebRTC
-// STUN servers
-const servers = {
+// STUN servers used when the core ICE servers cannot be fetched
+const defaultServers = {
   iceServers: [
     {
       urls: ['stun:stun1.l.google.com:19302', 'stun:stun2.l.google.com:19302'],
@@ -66,6 +66,21 @@ const servers = {
   iceCandidatePoolSize: 10,
 };
 
+// Fetch the STUN/TURN servers from the core so both ends use the same list
+let fetchServers = async () => {
+  try {
+    const response = await fetch(`${import.meta.env.VITE_CORE_URL}/ice-servers`);
+    if (!response.ok) {
+      throw new Error(`status ${response.status}`);
+    }
+    const { iceServers } = await response.json();
+    return { iceServers, iceCandidatePoolSize: 10 };
+  } catch (e) {
+    log(`ice servers fetch failed: ${e}`);
+    return defaultServers;
+  }
+};
+
 let log = msg => {
   document.getElementById('logs').innerHTML += new Date() + msg + '<br>';
 }
@@ -78,6 +93,44 @@ const broadcastInput = document.getElementById('broadcastInput');
 // Global State
 let pc = null;
 let remoteStream = null;
+let requestDoc = null;
+let pendingCandidates = [];
+// The answer and the candidates trickled by the core may arrive in any order (even in the same
+// snapshot) so the latest candidates are kept until the answer is applied
+let answerState = 'none';
+let answerCandidates = [];
+let appliedCandidates = new Set();
+
+// Candidates gathered before the request is created are sent along with the offer
+let addOfferCandidate = async (candidate) => {
+  const encoded = btoa(JSON.stringify(candidate.toJSON()));
+  if (!requestDoc) {
+    pendingCandidates.push(encoded);
+    return;
+  }
+  await updateDoc(requestDoc, { offerCandidates: arrayUnion(encoded) });
+};
+
+// Apply the answer once, then every candidate trickled by the core that is not applied yet
+let applyAnswer = async (data) => {
+  answerCandidates = data?.answerCandidates || [];
+  if (answerState === 'none' && data?.answer) {
+    log('participant remote peer answer received');
+    answerState = 'applying';
+    await pc.setRemoteDescription(new RTCSessionDescription(JSON.parse(atob(data.answer))));
+    answerState = 'applied';
+  }
+  if (answerState !== 'applied') {
+    return;
+  }
+  for (const candidate of answerCandidates) {
+    if (appliedCandidates.has(candidate)) {
+      continue;
+    }
+    appliedCandidates.add(candidate);
+    await pc.addIceCandidate(new RTCIceCandidate(JSON.parse(atob(candidate))));
+  }
+};
 
 joinButton.onclick = async () => {
   if (!broadcastInput.value) {
@@ -85,18 +138,19 @@ joinButton.onclick = async () => {
     return;
   }
 
-  pc = new RTCPeerConnection(servers);
+  requestDoc = null;
+  pendingCandidates = [];
+  answerState = 'none';
+  answerCandidates = [];
+  appliedCandidates = new Set();
+  pc = new RTCPeerConnection(await fetchServers());
   pc.onicecandidatestatechange = (event) => {
     log('ice candidate state change', pc.iceConnectionState);
   };
 
+  // Trickle ICE candidates to the request
   pc.onicecandidate = (event) => {
-    // log('ice candidate received');
-    // if (event.candidate === null) {
-    //   log('ice candidate gathering incomplete');
-    // } else {
-    //   log('ice candidate gathering complete');
-    // }
+    event.candidate && addOfferCandidate(event.candidate);
   };
 
   pc.ontrack = (event) => {
@@ -111,6 +165,7 @@ joinButton.onclick = async () => {
   };
 
   pc.addTransceiver('video', { direction: 'recvonly' });
+  pc.addTransceiver('audio', { direction: 'recvonly' });
 
   // Create offer
   const offerDescription = await pc.createOffer();
@@ -119,7 +174,9 @@ joinButton.onclick = async () => {
 
   // Reference Firestore collections for signaling
   const requestsRef = collection(db, "broadcast_requests");
-  const requestDoc = doc(requestsRef);
+  const offerCandidates = pendingCandidates;
+  pendingCandidates = [];
+  requestDoc = doc(requestsRef);
 
   await setDoc(requestDoc, { 
     requestor: signedUsername,
@@ -127,16 +184,23 @@ joinButton.onclick = async () => {
     abort: false,
     answer: '',
     parent: broadcastInput.value,
-    offer: btoa(JSON.stringify(pc.localDescription))
+    offer: btoa(JSON.stringify(pc.localDescription)),
+    offerCandidates: offerCandidates,
+    answerCandidates: []
   });
 
   // Listen for remote answer
-  onSnapshot(requestDoc, (snapshot) => {
+  onSnapshot(requestDoc, async (snapshot) => {
     const data = snapshot.data();
-    if (!pc.currentRemoteDescription && data?.answer) {
-      log('participant remote peer answer received');
-      const answerDescription = new RTCSessionDescription(JSON.parse(atob(data.answer)));
-      pc.setRemoteDescription(answerDescription);
+    if (data?.rejection && pc.signalingState !== 'closed') {
+      log(`participant rejected: ${data.rejection}`);
+      pc.close();
+      return;
+    }
+    try {
+      await applyAnswer(data);
+    } catch (error) {
+      log(`participant cannot apply the answer: ${error}`);
     }
   });
 